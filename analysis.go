@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// These types mirror the messages of
+// https://github.com/bazelbuild/bazel/blob/master/src/main/protobuf/analysis_v2.proto
+// as encoded by `bazel aquery --output=jsonproto`. Field names follow the
+// proto's json names, which encoding/json matches case-insensitively.
+//
+// They're written by hand rather than generated from the proto: the tool
+// has no dependencies besides the standard library, and the output of every
+// supported Bazel release has to decode into them, while the proto only
+// describes the latest one. Older releases print the ids as strings rather
+// than numbers, see aqueryID, and exec paths instead of path fragments.
+
+type actionGraphContainer struct {
+	Artifacts         []artifact
+	Actions           []action
+	Targets           []target
+	DepSetOfFiles     []depSetOfFiles
+	Configuration     []configuration
+	AspectDescriptors []aspectDescriptor
+	RuleClasses       []ruleClass
+	PathFragments     []pathFragment
+}
+
+type artifact struct {
+	ID             aqueryID `json:"id"`
+	PathFragmentID aqueryID `json:"pathFragmentId"`
+	// exec path of the artifact in the output of Bazel releases before
+	// path fragments
+	ExecPath       string
+	IsTreeArtifact bool
+}
+
+type action struct {
+	TargetID                aqueryID   `json:"targetId"`
+	AspectDescriptorIDs     []aqueryID `json:"aspectDescriptorIds"`
+	ActionKey               string
+	Mnemonic                string
+	ConfigurationID         aqueryID `json:"configurationId"`
+	Arguments               []string
+	EnvironmentVariables    []keyValuePair
+	InputDepSetIDs          []aqueryID `json:"inputDepSetIds"`
+	OutputIDs               []aqueryID `json:"outputIds"`
+	DiscoversInputs         bool
+	ExecutionInfo           []keyValuePair
+	ParamFiles              []paramFile
+	PrimaryOutputID         aqueryID `json:"primaryOutputId"`
+	ExecutionPlatform       string
+	TemplateContent         string
+	Substitutions           []keyValuePair
+	FileContents            string
+	UnresolvedSymlinkTarget string
+	IsExecutable            bool
+}
+
+type target struct {
+	ID          aqueryID `json:"id"`
+	Label       string
+	RuleClassID aqueryID `json:"ruleClassId"`
+}
+
+type aspectDescriptor struct {
+	ID         aqueryID `json:"id"`
+	Name       string
+	Parameters []keyValuePair
+}
+
+type depSetOfFiles struct {
+	ID                  aqueryID   `json:"id"`
+	DirectArtifactIDs   []aqueryID `json:"directArtifactIds"`
+	TransitiveDepSetIDs []aqueryID `json:"transitiveDepSetIds"`
+}
+
+type configuration struct {
+	ID           aqueryID `json:"id"`
+	Mnemonic     string
+	PlatformName string
+	Checksum     string
+	IsTool       bool
+}
+
+type keyValuePair struct {
+	Key   string
+	Value string
+}
+
+type ruleClass struct {
+	ID   aqueryID `json:"id"`
+	Name string
+}
+
+type paramFile struct {
+	ExecPath  string
+	Arguments []string
+}
+
+type pathFragment struct {
+	ID       aqueryID `json:"id"`
+	Label    string
+	ParentID aqueryID `json:"parentId"`
+}
+
+// aqueryID is an identifier in aquery output. Zero is never a valid id, it
+// is what an unset reference decodes to.
+type aqueryID int
+
+// UnmarshalJSON decodes an id encoded as an int, or as a string like older
+// Bazel releases do.
+func (id *aqueryID) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid id %q: %s", s, err)
+		}
+		*id = aqueryID(n)
+		return nil
+	}
+	var n int
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("invalid id %s: %s", b, err)
+	}
+	*id = aqueryID(n)
+	return nil
+}
+
+// actionGraph indexes the contents of an actionGraphContainer by id.
+type actionGraph struct {
+	actionGraphContainer
+	artifacts      map[aqueryID]artifact
+	targets        map[aqueryID]target
+	depSets        map[aqueryID]depSetOfFiles
+	configurations map[aqueryID]configuration
+	ruleClasses    map[aqueryID]ruleClass
+	pathFragments  map[aqueryID]pathFragment
+	paths          map[aqueryID]string
+	artifactPaths  map[string]bool
+	treeArtifacts  map[string]bool
+}
+
+func newActionGraph(c actionGraphContainer) *actionGraph {
+	g := &actionGraph{
+		actionGraphContainer: c,
+		artifacts:            map[aqueryID]artifact{},
+		targets:              map[aqueryID]target{},
+		depSets:              map[aqueryID]depSetOfFiles{},
+		configurations:       map[aqueryID]configuration{},
+		ruleClasses:          map[aqueryID]ruleClass{},
+		pathFragments:        map[aqueryID]pathFragment{},
+		paths:                map[aqueryID]string{},
+	}
+	for _, a := range c.Artifacts {
+		g.artifacts[a.ID] = a
+	}
+	for _, t := range c.Targets {
+		g.targets[t.ID] = t
+	}
+	for _, d := range c.DepSetOfFiles {
+		g.depSets[d.ID] = d
+	}
+	for _, c := range c.Configuration {
+		g.configurations[c.ID] = c
+	}
+	for _, r := range c.RuleClasses {
+		g.ruleClasses[r.ID] = r
+	}
+	for _, p := range c.PathFragments {
+		g.pathFragments[p.ID] = p
+	}
+	return g
+}
+
+// fragmentPath joins a path fragment with all of its parents.
+func (g *actionGraph) fragmentPath(id aqueryID) string {
+	if p, ok := g.paths[id]; ok {
+		return p
+	}
+	f, ok := g.pathFragments[id]
+	if !ok {
+		panic(fmt.Errorf("missing path fragment (%d) in aquery output", id))
+	}
+	p := f.Label
+	if f.ParentID != 0 {
+		p = g.fragmentPath(f.ParentID) + "/" + p
+	}
+	g.paths[id] = p
+	return p
+}
+
+// artifactPath returns the exec path of an artifact, which is relative to
+// the execution root.
+func (g *actionGraph) artifactPath(id aqueryID) string {
+	a, ok := g.artifacts[id]
+	if !ok {
+		panic(fmt.Errorf("missing artifact (%d) in aquery output", id))
+	}
+	if a.PathFragmentID == 0 {
+		return a.ExecPath
+	}
+	return g.fragmentPath(a.PathFragmentID)
+}
+
+// isArtifact reports whether p is the exec path of any artifact in g.
+func (g *actionGraph) isArtifact(p string) bool {
+	if g.artifactPaths == nil {
+		g.artifactPaths = make(map[string]bool, len(g.Artifacts))
+		for _, a := range g.Artifacts {
+			g.artifactPaths[g.artifactPath(a.ID)] = true
+		}
+	}
+	return g.artifactPaths[p]
+}
+
+// isTreeArtifact reports whether p is the exec path of a tree artifact in g.
+func (g *actionGraph) isTreeArtifact(p string) bool {
+	if g.treeArtifacts == nil {
+		g.treeArtifacts = map[string]bool{}
+		for _, a := range g.Artifacts {
+			if a.IsTreeArtifact {
+				g.treeArtifacts[g.artifactPath(a.ID)] = true
+			}
+		}
+	}
+	return g.treeArtifacts[p]
+}
+
+// inputs returns the exec paths of every input of a, in dep set order.
+func (g *actionGraph) inputs(a action) []string {
+	var paths []string
+	visited := map[aqueryID]bool{}
+	var visit func(id aqueryID)
+	visit = func(id aqueryID) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		d, ok := g.depSets[id]
+		if !ok {
+			panic(fmt.Errorf("missing dep set (%d) in aquery output", id))
+		}
+		for _, t := range d.TransitiveDepSetIDs {
+			visit(t)
+		}
+		for _, a := range d.DirectArtifactIDs {
+			paths = append(paths, g.artifactPath(a))
+		}
+	}
+	for _, id := range a.InputDepSetIDs {
+		visit(id)
+	}
+	return paths
+}
+
+// generatedInputs returns the exec paths of the inputs of a under bazel-out,
+// which a build creates.
+func (g *actionGraph) generatedInputs(a action) []string {
+	var generated []string
+	for _, in := range g.inputs(a) {
+		if strings.HasPrefix(in, "bazel-out/") {
+			generated = append(generated, in)
+		}
+	}
+	return generated
+}
+
+// isExecConfiguration reports whether c builds tools for the execution
+// platform rather than for the target.
+func isExecConfiguration(c configuration) bool {
+	return c.IsTool ||
+		c.Mnemonic == "host" ||
+		strings.Contains(c.Mnemonic, "-exec-") ||
+		strings.HasSuffix(c.Mnemonic, "-exec")
+}
+
+// configuration returns the configuration a was built in.
+func (g *actionGraph) configuration(a action) configuration {
+	c, ok := g.configurations[a.ConfigurationID]
+	if !ok {
+		panic(fmt.Errorf("missing configuration (%d) in aquery output", a.ConfigurationID))
+	}
+	return c
+}
+
+// label returns the label of the target that owns a, and false if the
+// target is missing from the aquery output.
+func (g *actionGraph) label(a action) (string, bool) {
+	t, ok := g.targets[a.TargetID]
+	return t.Label, ok
+}
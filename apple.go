@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// appleTripleSDKs maps the OS of Apple target triples, as in
+// arm64-apple-ios15.0-simulator, to their SDKs, by whether the triple names
+// a simulator.
+var appleTripleSDKs = map[string][2]string{
+	"macos":    {"macosx", "macosx"},
+	"macosx":   {"macosx", "macosx"},
+	"ios":      {"iphoneos", "iphonesimulator"},
+	"watchos":  {"watchos", "watchsimulator"},
+	"tvos":     {"appletvos", "appletvsimulator"},
+	"xros":     {"xros", "xrsimulator"},
+	"visionos": {"xros", "xrsimulator"},
+}
+
+// appleVersionMinSDKs maps the deployment target flags of Apple toolchains to
+// their SDKs.
+var appleVersionMinSDKs = map[string]string{
+	"-mmacosx-version-min=":            "macosx",
+	"-mmacos-version-min=":             "macosx",
+	"-miphoneos-version-min=":          "iphoneos",
+	"-mios-version-min=":               "iphoneos",
+	"-mios-simulator-version-min=":     "iphonesimulator",
+	"-mwatchos-version-min=":           "watchos",
+	"-mwatchos-simulator-version-min=": "watchsimulator",
+	"-mwatchsimulator-version-min=":    "watchsimulator",
+	"-mtvos-version-min=":              "appletvos",
+	"-mappletvos-version-min=":         "appletvos",
+	"-mtvos-simulator-version-min=":    "appletvsimulator",
+	"-mappletvsimulator-version-min=":  "appletvsimulator",
+}
+
+// appleSDK returns the SDK an action is compiled against, for xcrun --sdk:
+// the one of --apple-sdk, of the action's APPLE_SDK_PLATFORM, of its target
+// triple or deployment target flag, or macosx.
+func appleSDK(args []string, env map[string]string) string {
+	if *appleSDKFlag != "" {
+		return *appleSDKFlag
+	}
+	if p := env["APPLE_SDK_PLATFORM"]; p != "" {
+		// iPhoneSimulator, MacOSX, ...
+		return strings.ToLower(p)
+	}
+	for i, arg := range args {
+		var triple string
+		switch {
+		case arg == "-target" && i+1 < len(args):
+			triple = args[i+1]
+		case strings.HasPrefix(arg, "--target="):
+			triple = strings.TrimPrefix(arg, "--target=")
+		}
+		if sdk := tripleSDK(triple); sdk != "" {
+			return sdk
+		}
+		for flag, sdk := range appleVersionMinSDKs {
+			if strings.HasPrefix(arg, flag) {
+				return sdk
+			}
+		}
+	}
+	return "macosx"
+}
+
+// tripleSDK returns the SDK of an Apple target triple, or "" for triples of
+// other vendors. Mac Catalyst triples, like arm64-apple-ios14.0-macabi, use
+// the macOS SDK.
+func tripleSDK(triple string) string {
+	parts := strings.Split(triple, "-")
+	if len(parts) < 3 || parts[1] != "apple" {
+		return ""
+	}
+	system := strings.TrimRight(parts[2], "0123456789.")
+	sdks, ok := appleTripleSDKs[system]
+	if !ok {
+		return ""
+	}
+	if len(parts) > 3 {
+		switch parts[3] {
+		case "simulator":
+			return sdks[1]
+		case "macabi":
+			return "macosx"
+		}
+	}
+	return sdks[0]
+}
+
+// resolved SDK paths by SDK name
+var xcodeSDKPaths = map[string]string{}
+
+// xcodeSDKPath returns the path of an SDK of the host's Xcode. SDKs that
+// aren't installed, like the iOS SDKs on machines with only the Command Line
+// Tools, fall back to the macOS SDK.
+func xcodeSDKPath(sdk string) string {
+	if p, ok := xcodeSDKPaths[sdk]; ok {
+		return p
+	}
+	p := getXcodeSDKPath(executionRoot, sdk)
+	if p == "" && sdk != "macosx" {
+		fmt.Fprintf(os.Stderr, "warning: no %s SDK in %s, using the macOS SDK\n", sdk, xcodeDeveloperDir)
+		p = xcodeSDKPath("macosx")
+	}
+	xcodeSDKPaths[sdk] = p
+	return p
+}
+
+// xcrunCommand returns an xcrun command of the developer directory of
+// --developer-dir or DEVELOPER_DIR, if any.
+func xcrunCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("xcrun", args...)
+	if xcodeDeveloperDir != "" {
+		cmd.Env = append(os.Environ(), "DEVELOPER_DIR="+xcodeDeveloperDir)
+	}
+	return cmd
+}
+
+func getXcodeSDKPath(dir string, sdk string) string {
+	out := new(strings.Builder)
+	cmd := xcrunCommand("--sdk", sdk, "--show-sdk-path")
+	cmd.Stdout = out
+	cmd.Dir = dir
+	if err := cmd.Run(); err == nil {
+		return strings.TrimSpace(out.String())
+	}
+	// xcrun of the Command Line Tools may not know the SDK by name
+	if sdk == "macosx" {
+		p := path.Join(xcodeDeveloperDir, "SDKs", "MacOSX.sdk")
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// getXcodeDeveloperDir returns the developer directory of --developer-dir,
+// of the Xcode of --xcode-version or of DEVELOPER_DIR, or else the one
+// selected with xcode-select, which is /Library/Developer/CommandLineTools
+// on machines without Xcode.
+func getXcodeDeveloperDir(dir string) string {
+	if *developerDir != "" {
+		return *developerDir
+	}
+	if *xcodeVersion != "" {
+		return findXcode(*xcodeVersion)
+	}
+	if d := os.Getenv("DEVELOPER_DIR"); d != "" {
+		return d
+	}
+	out := new(strings.Builder)
+	cmd := exec.Command("xcode-select", "-p")
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not get xcode developer directory: %s\n", err)
+		return ""
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// xcodeVersionRegexp matches the version and build of an Xcode in its
+// Contents/version.plist.
+var xcodeVersionRegexp = regexp.MustCompile(`<key>(CFBundleShortVersionString|ProductBuildVersion)</key>\s*<string>([^<]*)</string>`)
+
+// findXcode returns the developer directory of the installed Xcode of
+// version, like 15.2 or 15.2.0.15C500b as in Bazel's --xcode_version. Xcodes
+// are looked up in /Applications, where tools like xcodes install them as
+// Xcode-15.2.0.app, and with Spotlight.
+func findXcode(version string) string {
+	apps, _ := filepath.Glob("/Applications/Xcode*.app")
+	if out, err := exec.Command("mdfind", "kMDItemCFBundleIdentifier == 'com.apple.dt.Xcode'").Output(); err == nil {
+		for _, app := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if app != "" {
+				apps = append(apps, app)
+			}
+		}
+	}
+	for _, app := range apps {
+		plist, err := os.ReadFile(path.Join(app, "Contents", "version.plist"))
+		if err != nil {
+			continue
+		}
+		var short, build string
+		for _, m := range xcodeVersionRegexp.FindAllStringSubmatch(string(plist), -1) {
+			if m[1] == "CFBundleShortVersionString" {
+				short = m[2]
+			} else {
+				build = m[2]
+			}
+		}
+		if short != "" && matchesXcodeVersion(version, short, build) {
+			return path.Join(app, "Contents", "Developer")
+		}
+	}
+	panic(fmt.Errorf("no Xcode %s found in /Applications or with Spotlight", version))
+}
+
+// matchesXcodeVersion reports whether an Xcode of the short version and
+// build is the one of version, ignoring trailing .0 components.
+func matchesXcodeVersion(version, short, build string) bool {
+	if version == build {
+		return true
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		// 15.2.0.15C500b
+		if parts[3] != build {
+			return false
+		}
+		parts = parts[:3]
+	}
+	trim := func(v []string) string {
+		for len(v) > 1 && v[len(v)-1] == "0" {
+			v = v[:len(v)-1]
+		}
+		return strings.Join(v, ".")
+	}
+	return trim(parts) == trim(strings.Split(short, "."))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+)
+
+// appleArchs are the architecture names used in Apple configuration
+// mnemonics, e.g. darwin_arm64-fastbuild. Longer names come first so they
+// aren't mistaken for their prefixes.
+var appleArchs = []string{"arm64_32", "arm64e", "arm64", "armv7k", "armv7", "x86_64", "i386"}
+
+// normalizeArch maps the different spellings of an architecture to the one
+// clang uses.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "amd64", "k8", "x64":
+		return "x86_64"
+	case "aarch64":
+		return "arm64"
+	}
+	return arch
+}
+
+// hostArch returns the architecture of the machine running the tool.
+func hostArch() string {
+	return normalizeArch(runtime.GOARCH)
+}
+
+// actionArch returns the target architecture of a compile action, from its
+// -arch or -target flags, or failing that from its configuration mnemonic.
+// It returns "" if the architecture is unknown.
+func actionArch(args []string, configuration string) string {
+	for i, arg := range args {
+		switch {
+		case (arg == "-arch" || arg == "-target") && i+1 < len(args):
+			return normalizeArch(strings.SplitN(args[i+1], "-", 2)[0])
+		case strings.HasPrefix(arg, "--target="):
+			return normalizeArch(strings.SplitN(strings.TrimPrefix(arg, "--target="), "-", 2)[0])
+		}
+	}
+	cpu := strings.SplitN(configuration, "-", 2)[0]
+	for _, arch := range appleArchs {
+		if strings.HasSuffix(cpu, "_"+arch) {
+			return arch
+		}
+	}
+	if cpu == "k8" || cpu == "aarch64" || cpu == "x64_windows" {
+		return normalizeArch(strings.TrimSuffix(cpu, "_windows"))
+	}
+	return ""
+}
+
+// preferArch drops the candidates built for another architecture than arch,
+// as long as at least one candidate is built for arch.
+func preferArch(candidates []sourceCandidate, arch string) []sourceCandidate {
+	var matching []sourceCandidate
+	for _, c := range candidates {
+		if c.arch == arch {
+			matching = append(matching, c)
+		}
+	}
+	if len(matching) == 0 {
+		return candidates
+	}
+	return matching
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// resolved bazel executable
+var bazelPath string
+
+// bazelBinary returns the bazel executable to run. On Windows bazel.exe and
+// bazelisk.exe are preferred over the bazel.cmd or bazel.bat scripts some
+// installs put on the PATH, whose arguments cmd.exe interprets.
+func bazelBinary() string {
+	if bazelPath != "" {
+		return bazelPath
+	}
+	bazelPath = "bazel"
+	if runtime.GOOS == "windows" {
+		for _, name := range []string{"bazel.exe", "bazelisk.exe", "bazel"} {
+			if p, err := exec.LookPath(name); err == nil {
+				bazelPath = p
+				break
+			}
+		}
+	}
+	return bazelPath
+}
+
+// isBatchScript reports whether p is a cmd.exe script.
+func isBatchScript(p string) bool {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".bat", ".cmd":
+		return true
+	}
+	return false
+}
+
+// temporary directory of the query files of queryFileArgs
+var queryFileDir string
+
+// queryFileArgs moves the query expression of the arguments of a query,
+// cquery or aquery command into a --query_file when bazel is a batch
+// script, as cmd.exe mangles the quotes and parentheses of expressions.
+func queryFileArgs(args []string) []string {
+	if len(args) < 2 || !isBatchScript(bazelBinary()) {
+		return args
+	}
+	switch args[0] {
+	case "query", "cquery", "aquery":
+	default:
+		return args
+	}
+	if queryFileDir == "" {
+		dir, err := os.MkdirTemp("", "query")
+		if err != nil {
+			panic(fmt.Errorf("failed to create temporary directory: %s", err))
+		}
+		queryFileDir = dir
+	}
+	f, err := os.CreateTemp(queryFileDir, "*.txt")
+	if err != nil {
+		panic(fmt.Errorf("failed to create query file: %s", err))
+	}
+	defer f.Close()
+	if _, err := f.WriteString(args[1]); err != nil {
+		panic(fmt.Errorf("failed to write query file: %s", err))
+	}
+	return append([]string{args[0], "--query_file=" + f.Name()}, args[2:]...)
+}
+
+// removeQueryFiles deletes the query files of queryFileArgs.
+func removeQueryFiles() {
+	if queryFileDir != "" {
+		os.RemoveAll(queryFileDir)
+		queryFileDir = ""
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// compilationPrerequisites is the output group of cc rules holding the
+// files needed to compile their sources, like generated headers, without the
+// compiled objects.
+const compilationPrerequisites = "compilation_prerequisites_INTERNAL_"
+
+// missingGeneratedInputs returns the labels of targets with compile actions
+// whose generated inputs don't exist in the execution root.
+func missingGeneratedInputs(ccTargets map[string]*ccTarget, labels []string) []string {
+	var missing []string
+	for _, label := range labels {
+		if hasMissingInput(ccTargets[label]) {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
+
+func hasMissingInput(t *ccTarget) bool {
+	for _, actions := range t.actions {
+		for _, a := range actions {
+			for _, in := range a.generatedInputs {
+				if _, err := os.Stat(path.Join(executionRoot, in)); err != nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// buildInputs runs `bazel build` according to --build before the database is
+// written, so the generated files it references exist.
+func buildInputs(cfg bazelConfig, ccTargets map[string]*ccTarget, labels []string) {
+	args := append([]string{"build", "--keep_going"}, analysisFlags(cfg)...)
+	switch *buildMode {
+	case "none":
+		return
+	case "required":
+		labels = missingGeneratedInputs(ccTargets, labels)
+		args = append(args, "--output_groups="+compilationPrerequisites)
+	case "all":
+	default:
+		panic(fmt.Errorf("invalid --build %q, expected none, required or all", *buildMode))
+	}
+	if len(labels) == 0 {
+		return
+	}
+	fmt.Printf("building generated inputs of %d targets\n", len(labels))
+	cmd := bazelCommand(append(append(args, "--"), labels...)...)
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to build generated inputs: %s\n", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "strings"
+
+// cxxOnlyFlagPrefixes are the prefixes of C++ flags that clang rejects or
+// warns about when compiling C, which toolchains and copts can apply to every
+// source of a target.
+var cxxOnlyFlagPrefixes = []string{
+	"-std=c++",
+	"-std=gnu++",
+	"-stdlib=",
+	"-fno-rtti",
+	"-frtti",
+	"-fcoroutines",
+	"-fsized-deallocation",
+	"-fno-sized-deallocation",
+}
+
+// dropCXXFlags drops the C++ flags from the arguments of C and assembly
+// sources, keeping their C standard flags.
+func dropCXXFlags(a *compileAction, args []string) []string {
+	if a.language != "c" && !strings.HasPrefix(a.language, "assembler") {
+		return args
+	}
+	return filterArgs(args, func(arg string) bool {
+		return !hasAnyPrefix(arg, cxxOnlyFlagPrefixes)
+	})
+}
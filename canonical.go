@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the workspace with its symlinks resolved, once resolved
+var realWorkspace string
+
+// canonicalSource returns the path file, a path of the database, is emitted
+// under with --canonicalize-sources, so that a source reached through
+// symlinks, like the bazel-<workspace> convenience symlink or a symlinked
+// directory, gets a single entry:
+//
+//   - off keeps the path of the source
+//   - realpath resolves every symlink, giving an absolute path
+//   - workspace-relative resolves them, and writes the sources in the
+//     workspace relative to it, like the other sources of the main repository
+func canonicalSource(file string) string {
+	switch *canonicalizeSources {
+	case "off":
+		return file
+	case "realpath", "workspace-relative":
+	default:
+		panic(fmt.Errorf("invalid --canonicalize-sources %q, expected off, realpath or workspace-relative", *canonicalizeSources))
+	}
+	host := file
+	if !isAbs(host) {
+		host = path.Join(workspace, host)
+	}
+	real, err := filepath.EvalSymlinks(host)
+	if err != nil {
+		return file
+	}
+	real = toSlash(real)
+	if *canonicalizeSources == "realpath" {
+		return real
+	}
+	if realWorkspace == "" {
+		realWorkspace = workspace
+		if p, err := filepath.EvalSymlinks(workspace); err == nil {
+			realWorkspace = toSlash(p)
+		}
+	}
+	if strings.HasPrefix(real, realWorkspace+"/") {
+		return strings.TrimPrefix(real, realWorkspace+"/")
+	}
+	return file
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// names of the entries of directories of the execution root, once read
+var dirNames = map[string][]string{}
+
+// onDiskCase returns p, an exec path, with the casing of the files on disk.
+// On the case insensitive file systems of macOS and Windows, labels can name
+// a file with another casing than its own, which would give the file several
+// entries that clangd tells apart. Paths that don't exist are returned as
+// they are.
+func onDiskCase(p string) string {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" || isAbs(p) || p == "" {
+		return p
+	}
+	parts := strings.Split(p, "/")
+	dir := ""
+	for i, part := range parts {
+		name := matchName(dir, part)
+		if name == "" {
+			return p
+		}
+		parts[i] = name
+		dir = path.Join(dir, name)
+	}
+	return strings.Join(parts, "/")
+}
+
+// matchName returns the name of the entry of dir, an exec path, that name
+// matches case insensitively, or "" if there is none.
+func matchName(dir, name string) string {
+	if name == "." || name == ".." {
+		return name
+	}
+	names, ok := dirNames[dir]
+	if !ok {
+		entries, _ := os.ReadDir(path.Join(executionRoot, dir))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		dirNames[dir] = names
+	}
+	match := ""
+	for _, n := range names {
+		if n == name {
+			return n
+		}
+		if strings.EqualFold(n, name) {
+			match = n
+		}
+	}
+	return match
+}
@@ -0,0 +1,21 @@
+package main
+
+// clangCLPathFlags are the MSVC-style flags of clang-cl that take a path.
+// clang-cl also accepts the gnu-style pathFlags.
+var clangCLPathFlags = []pathFlag{
+	{"/external:I", true},
+	{"/imsvc", true},
+	{"/I", true},
+	{"/FI", true},
+	{"/Fo", true},
+	{"/Fd", true},
+	{"/Fp", true},
+	{"/Yu", true},
+	{"/Yc", true},
+}
+
+// isClangCL reports whether compiler is clang-cl, whose MSVC-style
+// arguments are kept as they are.
+func isClangCL(compiler string) bool {
+	return compilerName(compiler) == "clang-cl"
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// clangStdVersions is the first clang major version that accepts each -std
+// value newer than C++17 and C17.
+var clangStdVersions = map[string]int{
+	"c++2a":   5,
+	"gnu++2a": 5,
+	"c++20":   10,
+	"gnu++20": 10,
+	"c++2b":   12,
+	"gnu++2b": 12,
+	"c++23":   17,
+	"gnu++23": 17,
+	"c++2c":   17,
+	"gnu++2c": 17,
+	"c++26":   17,
+	"gnu++26": 17,
+	"c2x":     9,
+	"gnu2x":   9,
+	"c23":     18,
+	"gnu23":   18,
+}
+
+// clangDiagnosticRegexps match the errors clang reports for arguments it
+// doesn't support, capturing the argument.
+var clangDiagnosticRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`unknown argument:? '([^']+)'`),
+	regexp.MustCompile(`invalid value '[^']*' in '([^']+)'`),
+	regexp.MustCompile(`unsupported option '([^']+)'`),
+	regexp.MustCompile(`Unknown command line argument '([^']+)'`),
+}
+
+// support of each probed argument by the consumer clang
+var clangSupport = map[string]bool{}
+
+// dropUnsupportedClangFlags drops the arguments that the clang named by
+// --clang-compat doesn't understand. Given a version, only -std values newer
+// than that version are dropped. Given a path to clang, it is probed with the
+// arguments of each action and all arguments it rejects are dropped.
+func dropUnsupportedClangFlags(a *compileAction, args []string) []string {
+	if *clangCompat == "" {
+		return args
+	}
+	if major, ok := clangMajorVersion(*clangCompat); ok {
+		return filterArgs(args, func(arg string) bool {
+			v, ok := clangStdVersions[strings.TrimPrefix(arg, "-std=")]
+			return !strings.HasPrefix(arg, "-std=") || !ok || v <= major
+		})
+	}
+	probeClang(*clangCompat, args)
+	kept := args[:1:1]
+	for _, unit := range clangArgUnits(args) {
+		if supported, ok := clangSupport[strings.Join(unit, " ")]; !ok || supported {
+			kept = append(kept, unit...)
+		}
+	}
+	return kept
+}
+
+// clangMajorVersion parses v as a clang version like 14 or 14.0.6.
+func clangMajorVersion(v string) (int, bool) {
+	major, err := strconv.Atoi(strings.SplitN(v, ".", 2)[0])
+	return major, err == nil
+}
+
+// probeClang runs clang with the flags of args it hasn't seen yet and
+// records which ones it accepts. Flags passing their value to another tool,
+// like -Xclang and -mllvm, are probed with it and recorded as one, joined by
+// a space. Path arguments aren't probed.
+func probeClang(clang string, args []string) {
+	var units [][]string
+	var flags []string
+	for _, unit := range clangArgUnits(args) {
+		if !strings.HasPrefix(unit[0], "-") || takesPath(unit[0]) {
+			continue
+		}
+		key := strings.Join(unit, " ")
+		if _, ok := clangSupport[key]; !ok {
+			clangSupport[key] = true
+			units = append(units, unit)
+			flags = append(flags, unit...)
+		}
+	}
+	if len(flags) == 0 {
+		return
+	}
+
+	out := new(strings.Builder)
+	cmd := exec.Command(clang, append(append([]string{"-fsyntax-only"}, flags...), "-x", "c++", "-")...)
+	cmd.Stdin = strings.NewReader("")
+	cmd.Stderr = out
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		panic(fmt.Errorf("failed to run %s for --clang-compat: %s", clang, err))
+	}
+	// the tool given the value of a pair only names the value
+	for _, re := range clangDiagnosticRegexps {
+		for _, m := range re.FindAllStringSubmatch(out.String(), -1) {
+			for _, unit := range units {
+				if unit[0] == m[1] || unit[len(unit)-1] == m[1] {
+					clangSupport[strings.Join(unit, " ")] = false
+				}
+			}
+		}
+	}
+}
+
+// clangArgUnits splits the arguments after the compiler into the ones kept
+// or dropped together: flags with their value in the next argument, like
+// the path flags and the flags passing it to another tool, and single
+// arguments.
+func clangArgUnits(args []string) [][]string {
+	var units [][]string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if i+1 < len(args) && (takesPath(arg) || passesValue(arg)) {
+			units = append(units, args[i:i+2])
+			i++
+			continue
+		}
+		units = append(units, args[i:i+1])
+	}
+	return units
+}
+
+// passesValue reports whether arg passes the next argument to another tool
+// or to the compilation of another architecture, like -Xclang, -Xlinker,
+// -Xarch_arm64 and -mllvm.
+func passesValue(arg string) bool {
+	return arg == "-mllvm" || strings.HasPrefix(arg, "-X") && !strings.Contains(arg, "=")
+}
+
+// takesPath reports whether arg is one of pathFlags with its path in the
+// next argument.
+func takesPath(arg string) bool {
+	for _, f := range pathFlags {
+		if arg == f.flag {
+			return true
+		}
+	}
+	return false
+}
+
+// filterArgs returns the arguments after the compiler that keep accepts.
+func filterArgs(args []string, keep func(arg string) bool) []string {
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i == 0 || keep(arg) {
+			out = append(out, arg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// fakeClang rejects -fbogus, whether given to the driver or to cc1, and the
+// LLVM option -bogus-llvm.
+const fakeClang = `#!/bin/sh
+for a; do
+	case "$a" in
+	-fbogus) echo "clang: error: unknown argument: '-fbogus'" >&2 ;;
+	-bogus-llvm) echo "clang (LLVM option parsing): Unknown command line argument '-bogus-llvm'." >&2 ;;
+	esac
+done
+exit 1
+`
+
+func TestDropUnsupportedClangFlags(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake clang is a shell script")
+	}
+	clang := filepath.Join(t.TempDir(), "clang")
+	if err := os.WriteFile(clang, []byte(fakeClang), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(c string, s map[string]bool) { *clangCompat, clangSupport = c, s }(*clangCompat, clangSupport)
+	*clangCompat = clang
+	clangSupport = map[string]bool{}
+
+	tests := []struct {
+		args, want []string
+	}{
+		{
+			[]string{"gcc", "-fbogus", "-Wall", "-c", "a.cc"},
+			[]string{"gcc", "-Wall", "-c", "a.cc"},
+		},
+		{
+			[]string{"gcc", "-Xclang", "-fbogus", "-Xclang", "-fcolor-diagnostics", "a.cc"},
+			[]string{"gcc", "-Xclang", "-fcolor-diagnostics", "a.cc"},
+		},
+		{
+			[]string{"gcc", "-mllvm", "-bogus-llvm", "-mllvm", "-inline-threshold=100", "a.cc"},
+			[]string{"gcc", "-mllvm", "-inline-threshold=100", "a.cc"},
+		},
+		{
+			[]string{"gcc", "-Xarch_arm64", "-fbogus", "-Xarch_arm64", "-O2", "a.cc"},
+			[]string{"gcc", "-Xarch_arm64", "-O2", "a.cc"},
+		},
+		{
+			[]string{"gcc", "-isystem", "-fbogus", "a.cc"},
+			[]string{"gcc", "-isystem", "-fbogus", "a.cc"},
+		},
+	}
+	for _, tt := range tests {
+		if got := dropUnsupportedClangFlags(nil, tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dropUnsupportedClangFlags(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// clangdConfigHeader starts the .clangd files written by the tool, which it
+// may overwrite.
+const clangdConfigHeader = "# Fallback flags of the files without entries in compile_commands.json,\n# written by bazel-compile-commands.\n"
+
+// writeClangdConfig writes a .clangd file to the workspace giving the files
+// without an entry in compileCommands, like scratch files and generated code
+// no target compiles yet, the fallback flags of fallbackFlags. A .clangd file
+// written by hand is left alone.
+func writeClangdConfig(compileCommands []compileCommand) {
+	name := path.Join(workspace, ".clangd")
+	if content, err := ioutil.ReadFile(name); err == nil && !strings.HasPrefix(string(content), clangdConfigHeader) {
+		fmt.Fprintf(os.Stderr, "warning: not overwriting %s, which wasn't written by this tool\n", name)
+		return
+	}
+	var excludes []string
+	for _, c := range compileCommands {
+		if f := workspaceFile(c); f != "" {
+			excludes = append(excludes, regexp.QuoteMeta(f))
+		}
+	}
+	var b strings.Builder
+	b.WriteString(clangdConfigHeader)
+	if len(excludes) > 0 {
+		fmt.Fprintf(&b, "If:\n  PathExclude: %s\n", yamlList(excludes))
+	}
+	fmt.Fprintf(&b, "CompileFlags:\n  Add: %s\n", yamlList(fallbackFlags(compileCommands)))
+	if err := ioutil.WriteFile(name, []byte(b.String()), 0644); err != nil {
+		panic(fmt.Errorf("failed to write %s: %s", name, err))
+	}
+}
+
+// fallbackFlags returns the flags of most of the entries: the include
+// directories, made absolute, the defines and the language standard, after
+// the workspace and bazel-bin, the include roots of the labels of Bazel.
+func fallbackFlags(compileCommands []compileCommand) []string {
+	flags := []string{"-I" + workspace, "-I" + binDir}
+	var order []string
+	counts := map[string]int{}
+	for _, c := range compileCommands {
+		seen := map[string]bool{}
+		args := c.Arguments
+		if len(args) == 0 {
+			args = splitCommand(c.Command)
+		}
+		for i := 1; i < len(args); i++ {
+			var flag string
+			if f, dir, separate := includeFlag(args, i); f != "" {
+				if separate {
+					i++
+				}
+				if !isAbs(dir) {
+					dir = path.Join(c.Directory, dir)
+				}
+				flag = f + filepath.ToSlash(dir)
+			} else if strings.HasPrefix(args[i], "-D") || strings.HasPrefix(args[i], "-std=") {
+				flag = args[i]
+			}
+			if flag == "" || seen[flag] {
+				continue
+			}
+			seen[flag] = true
+			if counts[flag] == 0 {
+				order = append(order, flag)
+			}
+			counts[flag]++
+		}
+	}
+	for _, flag := range order {
+		if counts[flag]*2 > len(compileCommands) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// yamlList returns values as a YAML flow sequence of quoted strings.
+func yamlList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		q, _ := json.Marshal(v)
+		quoted[i] = string(q)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// quietly runs f with the progress the tool prints written to stderr, so
+// that the output of commands is all that's on stdout.
+func quietly(f func()) {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+	f()
+}
+
+// files prints the source files of the databases, one per line, to feed
+// tools like clang-format or clang-tidy only the files with entries. Files
+// in the workspace are relative to it. It is run like
+// `generate_compile_commands files --target //foo:bar`, where the targets
+// replace the universe.
+func files(root string, args []string) {
+	fs := flag.NewFlagSet("files", flag.ExitOnError)
+	var targets stringList
+	fs.Var(&targets, "target", "`label` of a target whose files are printed, instead of the ones of the universe; may be repeated")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		panic(fmt.Errorf("usage: files [--target <label>]..."))
+	}
+	if len(targets) > 0 {
+		*universeExpr = strings.Join(targets, " + ")
+		*targetsFile = ""
+	}
+	var databases []database
+	quietly(func() {
+		databases = generateDatabases(root)
+	})
+	printed := map[string]bool{}
+	for _, db := range databases {
+		for _, c := range db.commands {
+			if c.File == "" {
+				continue
+			}
+			f := workspaceFile(c)
+			if f == "" {
+				f = entryPath(c)
+			}
+			if !printed[f] {
+				printed[f] = true
+				fmt.Println(f)
+			}
+		}
+	}
+}
+
+// commandPath returns p, a path given to a command, as an absolute path.
+// Relative paths are relative to the directory `bazel run` was run in.
+func commandPath(p string) string {
+	p = toSlash(p)
+	if isAbs(p) {
+		return path.Clean(p)
+	}
+	dir := os.Getenv("BUILD_WORKING_DIRECTORY")
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			panic(err)
+		}
+	}
+	return path.Join(hostPath(dir), p)
+}
+
+// workspaceFiles are the files marking the root of a workspace.
+var workspaceFiles = []string{"MODULE.bazel", "REPO.bazel", "WORKSPACE.bazel", "WORKSPACE"}
+
+// findWorkspace returns the workspace containing the working directory, the
+// nearest directory above it with one of workspaceFiles, like Bazel finds it.
+func findWorkspace() string {
+	start := commandPath(".")
+	for dir := start; ; {
+		for _, name := range workspaceFiles {
+			if info, err := os.Stat(path.Join(dir, name)); err == nil && !info.IsDir() {
+				return dir
+			}
+		}
+		parent := path.Dir(dir)
+		if len(parent) == 2 && hasDriveLetter(parent+"/") {
+			parent += "/"
+		}
+		if parent == dir {
+			panic(fmt.Errorf("%s is not in a Bazel workspace", start))
+		}
+		dir = parent
+	}
+}
+
+// entriesOf returns the entries of databases for the file p, a path given to
+// a command, after generating them.
+func entriesOf(root, p string) []compileCommand {
+	var databases []database
+	quietly(func() {
+		databases = generateDatabases(root)
+	})
+	p = commandPath(p)
+	var entries []compileCommand
+	for _, db := range databases {
+		for _, c := range db.commands {
+			if c.File != "" && entryPath(c) == p {
+				entries = append(entries, c)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		panic(fmt.Errorf("no entry for %s", p))
+	}
+	return entries
+}
+
+// explain prints where the arguments of the entries of a file come from: the
+// target, the mnemonic and the configuration of the action, and the
+// arguments of the entry, annotated with the rewrites that added them,
+// followed by the ones the rewrites removed. Paths aren't mapped by the
+// options of the written database, like --path-map.
+func explain(root string, args []string) {
+	if len(args) != 1 {
+		panic(fmt.Errorf("usage: explain <path>"))
+	}
+	for i, c := range entriesOf(root, args[0]) {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s\n", c.File)
+		fmt.Printf("  target:        %s\n", apparentLabel(c.label))
+		a := c.action
+		if a == nil {
+			fmt.Printf("  arguments of the CcInfo of the target, which has no compile actions\n")
+			printArgs(c.Arguments, nil)
+			continue
+		}
+		fmt.Printf("  mnemonic:      %s\n", a.mnemonic)
+		fmt.Printf("  configuration: %s\n", a.configuration)
+		if a.src != "" && execPath(a.src) != c.File {
+			fmt.Printf("  action of:     %s\n", a.src)
+		}
+		if a.compiler != "" && a.compiler != c.Arguments[0] {
+			fmt.Printf("  compiler:      %s, replaced by %s\n", a.compiler, c.Arguments[0])
+		}
+		if len(a.rewrites) > 0 {
+			fmt.Printf("  rewrites:      %s\n", strings.Join(a.rewrites, ", "))
+		}
+		annotations := map[string]string{}
+		for _, change := range a.changes {
+			for _, arg := range change.added {
+				annotations[arg] = change.rewrite
+			}
+		}
+		added, _ := diffArgs(a.args, c.Arguments[:len(c.Arguments)-1])
+		iquote := map[string]bool{}
+		for _, arg := range iquoteRoots(a.args) {
+			iquote[arg] = true
+		}
+		generated, _ := diffArgs(a.args, generatorArgs(a))
+		for _, arg := range generated {
+			iquote[arg] = false
+		}
+		for _, arg := range added {
+			if v, ok := iquote[arg]; ok && v {
+				annotations[arg] = "iquote-roots"
+			} else if ok {
+				annotations[arg] = "generated-includes"
+			} else {
+				annotations[arg] = "header-language"
+			}
+		}
+		printArgs(c.Arguments, annotations)
+		var removed bool
+		for _, change := range a.changes {
+			for _, arg := range change.removed {
+				if !removed {
+					fmt.Printf("  removed:\n")
+					removed = true
+				}
+				fmt.Printf("    %-40s # %s\n", arg, change.rewrite)
+			}
+		}
+	}
+}
+
+// printArgs prints the arguments of an entry, one per line, each followed by
+// its annotation, if any.
+func printArgs(args []string, annotations map[string]string) {
+	fmt.Printf("  arguments:\n")
+	for _, arg := range args {
+		if a, ok := annotations[arg]; ok {
+			fmt.Printf("    %-40s # %s\n", arg, a)
+		} else {
+			fmt.Printf("    %s\n", arg)
+		}
+	}
+}
+
+// flagsOf prints the arguments of the entry of a file in a database written
+// before, one per line, without running Bazel, for shell tools and editor
+// plugins. Entries in the command form are split like a POSIX shell does.
+func flagsOf(args []string) {
+	fs := flag.NewFlagSet("flags", flag.ExitOnError)
+	db := fs.String("database", "compile_commands.json", "`file` in the workspace to read the entry from")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		panic(fmt.Errorf("usage: flags [--database <file>] <path>"))
+	}
+	name := *db
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(workspace, name)
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		panic(fmt.Errorf("failed to read compilation database: %s", err))
+	}
+	var compileCommands []compileCommand
+	if err := json.Unmarshal(content, &compileCommands); err != nil {
+		panic(fmt.Errorf("failed to parse compilation database %s: %s", name, err))
+	}
+	p := commandPath(fs.Arg(0))
+	for _, c := range compileCommands {
+		if c.File == "" || entryPath(c) != p {
+			continue
+		}
+		arguments := c.Arguments
+		if len(arguments) == 0 {
+			arguments = splitCommand(c.Command)
+		}
+		for _, arg := range arguments {
+			fmt.Println(arg)
+		}
+		return
+	}
+	panic(fmt.Errorf("no entry for %s in %s", p, name))
+}
+
+// owner prints the labels of the targets compiling a file, or including it
+// when it's a header, one per line, to know what to build or test after
+// editing it. Every target is printed, whatever --duplicate-sources.
+func owner(root string, args []string) {
+	if len(args) != 1 {
+		panic(fmt.Errorf("usage: owner <path>"))
+	}
+	*duplicateSources = "all"
+	var labels []string
+	printed := map[string]bool{}
+	for _, c := range entriesOf(root, args[0]) {
+		label := apparentLabel(c.label)
+		if !printed[label] {
+			printed[label] = true
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Println(label)
+	}
+}
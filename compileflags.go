@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// writeCompileFlags writes compileCommands as compile_flags.txt files, for
+// tools that only read those: one at the root of the workspace with the
+// flags of most entries, and one in each directory whose entries mostly use
+// other flags than the ones the nearest file above it gives them.
+func writeCompileFlags(compileCommands []compileCommand) {
+	byDir := map[string][]compileCommand{}
+	var dirs []string
+	for _, c := range compileCommands {
+		f := workspaceFile(c)
+		if f == "" {
+			continue
+		}
+		dir := path.Dir(f)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], c)
+	}
+	// parents sort before their subdirectories
+	sort.Strings(dirs)
+
+	written := map[string][]string{".": fallbackFlags(compileCommands)}
+	writeFlagsFile(".", written["."])
+	for _, dir := range dirs {
+		flags := fallbackFlags(byDir[dir])
+		if equalArgs(flags, inheritedFlags(written, dir)) {
+			continue
+		}
+		written[dir] = flags
+		writeFlagsFile(dir, flags)
+	}
+}
+
+// inheritedFlags returns the flags of the compile_flags.txt file nearest to
+// dir in written, by directory relative to the workspace.
+func inheritedFlags(written map[string][]string, dir string) []string {
+	for ; dir != "."; dir = path.Dir(dir) {
+		if flags, ok := written[dir]; ok {
+			return flags
+		}
+	}
+	return written["."]
+}
+
+// writeFlagsFile writes flags, one per line, to compile_flags.txt in dir,
+// relative to the workspace.
+func writeFlagsFile(dir string, flags []string) {
+	name := path.Join(workspace, dir, "compile_flags.txt")
+	if err := ioutil.WriteFile(name, []byte(strings.Join(flags, "\n")+"\n"), 0644); err != nil {
+		panic(fmt.Errorf("failed to write %s: %s", name, err))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// compiler returns the compiler executable to put first in the entries of an
+// action whose first argument is arg0, according to --compiler.
+func compiler(arg0 string) string {
+	if *compilerFlag != "action" {
+		return *compilerFlag
+	}
+	p := compilerPath(arg0)
+	fetchToolchainRepo(p)
+	if *unwrapCompilerFlag {
+		p = unwrapCompiler(p)
+		fetchToolchainRepo(p)
+	}
+	return p
+}
+
+// compilerPath resolves the compiler path of an action, which is relative to
+// the execution root, to an absolute path. Bare names are looked up in PATH
+// by the consumer, like Bazel does, and are left as they are.
+func compilerPath(p string) string {
+	p = msysPath(toSlash(p))
+	if isAbs(p) || !strings.Contains(p, "/") {
+		return p
+	}
+	if resolved := execPath(p); isAbs(resolved) {
+		return resolved
+	}
+	return path.Join(executionRoot, p)
+}
+
+// compilerName returns the lowercase file name of a compiler without its
+// .exe extension.
+func compilerName(p string) string {
+	name := strings.ToLower(path.Base(strings.ReplaceAll(p, `\`, "/")))
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// launchers are caching and remote execution tools that toolchains put in
+// front of the compiler.
+var launchers = map[string]bool{
+	"buildcache": true,
+	"ccache":     true,
+	"distcc":     true,
+	"gomacc":     true,
+	"icecc":      true,
+	"rewrapper":  true,
+	"sccache":    true,
+}
+
+// stripLaunchers drops the launchers and spawn wrappers, and their own flags,
+// from the front of the arguments of a compile action, so that they start
+// with the compiler.
+func stripLaunchers(args []string) []string {
+	for stripped := true; stripped; {
+		args, stripped = stripSpawnWrapper(args)
+	}
+	for len(args) > 1 && launchers[strings.TrimSuffix(path.Base(args[0]), ".exe")] {
+		args = args[1:]
+		for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+			flag := args[0]
+			args = args[1:]
+			if flag == "--" {
+				break
+			}
+		}
+	}
+	return args
+}
+
+// unwrapped compilers by wrapper path
+var unwrappedCompilers = map[string]string{}
+
+// wrapperCallRegexp matches the line of a wrapper script that passes its
+// arguments on to the compiler, capturing the compiler in one of the groups
+// of its double quoted, single quoted or bare forms.
+var wrapperCallRegexp = regexp.MustCompile(`(?m)^\s*(?:exec\s+)?(?:"([^"]+)"|'([^']+)'|([^"'\s]+))\s+"\$@"`)
+
+// wrapperExecRootRegexp matches the line of the wrapper of toolchains_llvm
+// that passes its arguments on to clang, relative to the execution root or
+// absolute, capturing clang.
+var wrapperExecRootRegexp = regexp.MustCompile(`(?m)^\s*(?:exec\s+)?"\$\{execroot_(?:abs_)?path\}([^"$]+)"\s+"\$`)
+
+// wrapperSiblingRegexp matches a compiler next to the wrapper script, as in
+// "$(dirname "$0")"/wrapped_clang "$@", capturing its name.
+var wrapperSiblingRegexp = regexp.MustCompile(`(?m)^\s*(?:exec\s+)?"?\$\(dirname "?\$0"?\)"?/([\w.+-]+)\s+"\$@"`)
+
+// unwrapCompiler returns the compiler invoked by p if p is one of the wrapper
+// scripts of Bazel's C++ toolchains, which tools like clangd can't
+// interrogate, or p itself. The wrappers don't add compiler flags of their
+// own, so the action's arguments stay valid for the unwrapped compiler.
+func unwrapCompiler(p string) string {
+	if c, ok := unwrappedCompilers[p]; ok {
+		return c
+	}
+	c := p
+	switch path.Base(p) {
+	case "wrapped_clang":
+		c = xcrunFind("clang")
+	case "wrapped_clang_pp":
+		c = xcrunFind("clang++")
+	case "cc_wrapper.sh", "cc_wrapper":
+		if content, err := os.ReadFile(p); err == nil {
+			if m := wrapperSiblingRegexp.FindSubmatch(content); m != nil {
+				c = unwrapCompiler(path.Join(path.Dir(p), string(m[1])))
+			} else if m := wrapperCallRegexp.FindSubmatch(content); m != nil {
+				c = compilerPath(string(m[1]) + string(m[2]) + string(m[3]))
+			} else if m := wrapperExecRootRegexp.FindSubmatch(content); m != nil {
+				c = compilerPath(string(m[1]))
+			}
+		}
+	}
+	unwrappedCompilers[p] = c
+	return c
+}
+
+// xcrunFind returns the path of an Xcode tool, or its bare name if xcrun
+// can't find it.
+func xcrunFind(tool string) string {
+	out := new(strings.Builder)
+	cmd := xcrunCommand("--find", tool)
+	cmd.Stdout = out
+	if err := cmd.Run(); err != nil {
+		return tool
+	}
+	return strings.TrimSpace(out.String())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bazelConfig is a named set of build flags passed to every bazel command
+// that analyzes the build.
+type bazelConfig struct {
+	name  string
+	flags []string
+}
+
+// analysisFlags returns the flags passed to bazel commands that analyze the
+// build in cfg: the flags forwarded from the command line, then cfg's own.
+func analysisFlags(cfg bazelConfig) []string {
+	var flags []string
+	switch *compilationMode {
+	case "":
+	case "dbg", "fastbuild", "opt":
+		flags = append(flags, "--compilation_mode="+*compilationMode)
+	default:
+		panic(fmt.Errorf("invalid --compilation-mode %q, expected dbg, fastbuild or opt", *compilationMode))
+	}
+	if *targetPlatform != "" {
+		flags = append(flags, "--platforms="+*targetPlatform)
+	}
+	if *targetCPU != "" {
+		flags = append(flags, "--cpu="+*targetCPU)
+	}
+	return append(flags, cfg.flags...)
+}
+
+// primaryBazelConfig returns the configuration of --primary-config among
+// configs, which defaults to the first one, or the default configuration if
+// there are no configs.
+func primaryBazelConfig(configs []bazelConfig) bazelConfig {
+	if len(configs) == 0 {
+		return bazelConfig{}
+	}
+	if *primaryConfig == "" {
+		return configs[0]
+	}
+	for _, cfg := range configs {
+		if cfg.name == *primaryConfig {
+			return cfg
+		}
+	}
+	panic(fmt.Errorf("primary configuration %q is not one of --configs", *primaryConfig))
+}
+
+// parseConfigs parses the value of --configs, a comma separated list of
+// name=flags, where flags are separated by spaces.
+func parseConfigs(v string) []bazelConfig {
+	var configs []bazelConfig
+	seen := map[string]bool{}
+	for _, c := range strings.Split(v, ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		parts := strings.SplitN(c, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			panic(fmt.Errorf("invalid configuration %q, expected name=flags", c))
+		}
+		name, flags := parts[0], parts[1]
+		if seen[name] {
+			panic(fmt.Errorf("configuration %q is given more than once", name))
+		}
+		seen[name] = true
+		configs = append(configs, bazelConfig{
+			name:  name,
+			flags: splitCommand(flags),
+		})
+	}
+	return configs
+}
+
+// mergeConfigs merges the compile commands of several configurations into a
+// single database. Every entry of the primary configuration is kept, the
+// other configurations only contribute files that aren't covered yet, in the
+// order they were given.
+func mergeConfigs(configs []bazelConfig, primary string, results map[string][]compileCommand) []compileCommand {
+	merged := append([]compileCommand{}, results[primary]...)
+	covered := map[string]bool{}
+	for _, c := range merged {
+		covered[c.File] = true
+	}
+	for _, cfg := range configs {
+		if cfg.name == primary {
+			continue
+		}
+		for _, c := range results[cfg.name] {
+			if !covered[c.File] {
+				covered[c.File] = true
+				merged = append(merged, c)
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// convenienceMappings returns the rules replacing the execution root of ws
+// and its bazel-out directory by the convenience symlinks of ws pointing to
+// them, like bazel-<workspace> and bazel-out, with --convenience-symlinks.
+// Paths through the symlinks are relative to the workspace, the directory of
+// the entries, so they stay valid when the output base changes, like after
+// `bazel clean --expunge`. Files of external repositories are reached through
+// the external directory of the execution root, where Bazel links the
+// repositories actions use.
+func convenienceMappings(ws *workspaceState) []string {
+	if !*convenienceSymlinks {
+		return nil
+	}
+	entries, err := os.ReadDir(ws.dir)
+	if err != nil {
+		panic(fmt.Errorf("failed to read workspace: %s", err))
+	}
+	var execRootLink, outLink string
+	for _, e := range entries {
+		if !isLink(e.Type()) {
+			continue
+		}
+		target, err := readLink(path.Join(ws.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		switch path.Clean(toSlash(target)) {
+		case ws.executionRoot:
+			execRootLink = e.Name()
+		case path.Join(ws.executionRoot, "bazel-out"):
+			outLink = e.Name()
+		}
+	}
+	var rules []string
+	if execRootLink != "" {
+		rules = append(rules, ws.executionRoot+"="+execRootLink)
+		if _, err := os.Stat(path.Join(ws.executionRoot, "external")); err == nil {
+			rules = append(rules, ws.outputBaseDir+"/external="+execRootLink+"/external")
+		}
+	}
+	if outLink != "" {
+		rules = append(rules, ws.executionRoot+"/bazel-out="+outLink)
+	}
+	if len(rules) == 0 {
+		fmt.Fprintf(os.Stderr, "warning: no convenience symlinks to the execution root in %s, build once to create them\n", ws.dir)
+	}
+	return rules
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// headerExtensions are the extensions of the headers the coverage report
+// looks for, including textual ones like .inc and .def files, besides the
+// ones of sourceLanguages.
+var headerExtensions = map[string]bool{
+	".h":   true,
+	".hh":  true,
+	".hpp": true,
+	".hxx": true,
+	".h++": true,
+	".ipp": true,
+	".inc": true,
+	".inl": true,
+	".def": true,
+	".tcc": true,
+	".tpp": true,
+}
+
+// writeCoverageReport writes the report of --coverage to the named file in
+// the workspace, or to stdout with -: the sources and headers of the
+// workspace without an entry in any of databases, grouped by directory, like
+// the sources missing from BUILD files or filtered out by mistake. Hidden
+// directories, the convenience symlinks and the directories of .bazelignore
+// are skipped.
+func writeCoverageReport(name string, databases []database) {
+	covered := map[string]bool{}
+	for _, db := range databases {
+		for _, c := range db.commands {
+			if f := workspaceFile(c); f != "" {
+				covered[f] = true
+			}
+		}
+	}
+	missing := map[string][]string{}
+	var dirs []string
+	total := 0
+	err := filepath.WalkDir(workspace, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workspace, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || isIgnored(rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := path.Ext(rel)
+		if _, ok := sourceLanguages[ext]; !ok && !headerExtensions[ext] || !d.Type().IsRegular() {
+			return nil
+		}
+		total++
+		if covered[rel] {
+			return nil
+		}
+		dir := path.Dir(rel)
+		if _, ok := missing[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		missing[dir] = append(missing[dir], path.Base(rel))
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to walk workspace: %s", err))
+	}
+	sort.Strings(dirs)
+
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.Create(path.Join(workspace, name))
+		if err != nil {
+			panic(fmt.Errorf("failed to write coverage report: %s", err))
+		}
+		defer f.Close()
+		w = f
+	}
+	n := 0
+	for _, files := range missing {
+		n += len(files)
+	}
+	fmt.Fprintf(w, "%d of %d sources and headers in the workspace have no entry\n", n, total)
+	for _, dir := range dirs {
+		fmt.Fprintf(w, "\n%s/ (%d)\n", dir, len(missing[dir]))
+		for _, f := range missing[dir] {
+			fmt.Fprintf(w, "  %s\n", f)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// dedupFlags drops repeated include directories and defines with
+// --dedup-flags, which Bazel's actions are full of. Only repetitions without
+// effect are dropped: an include directory is searched at its first
+// occurrence only, and a -D or -U is dropped when the last -D or -U of the
+// same macro is identical.
+func dedupFlags(a *compileAction, args []string) []string {
+	if !*dedupFlagsFlag {
+		return args
+	}
+	out := make([]string, 0, len(args))
+	includes := map[string]bool{}
+	macros := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if flag, dir, separate := includeFlag(args, i); i > 0 && flag != "" {
+			n := 1
+			if separate {
+				n = 2
+			}
+			if key := flag + " " + path.Clean(dir); !includes[key] {
+				includes[key] = true
+				out = append(out, args[i:i+n]...)
+			}
+			i += n - 1
+			continue
+		}
+		if i > 0 && (strings.HasPrefix(arg, "-D") || strings.HasPrefix(arg, "-U")) && len(arg) > 2 {
+			name := strings.SplitN(arg[2:], "=", 2)[0]
+			if macros[name] == arg {
+				continue
+			}
+			macros[name] = arg
+		}
+		out = append(out, arg)
+	}
+	return out
+}
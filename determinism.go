@@ -0,0 +1,46 @@
+package main
+
+import "strings"
+
+// determinismFlags are the flags Bazel's toolchains add to make each object
+// file reproducible, or to write its dependency file, which are noise for
+// editors and make the entries of a target differ per object.
+var determinismFlags = map[string]bool{
+	"-MD":                          true,
+	"-MMD":                         true,
+	"-MP":                          true,
+	"-Wno-builtin-macro-redefined": true,
+	`-D__DATE__="redacted"`:        true,
+	`-D__TIMESTAMP__="redacted"`:   true,
+	`-D__TIME__="redacted"`:        true,
+}
+
+// determinismFlagsWithValue are the flags of determinismFlags that take a
+// value in the next argument.
+var determinismFlagsWithValue = map[string]bool{
+	"-MF": true,
+	"-MT": true,
+	"-MQ": true,
+}
+
+// stripDeterminismFlags drops the determinismFlags with
+// --strip-determinism-flags, along with -frandom-seed=.
+func stripDeterminismFlags(a *compileAction, args []string) []string {
+	if !*stripDeterminism {
+		return args
+	}
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case i == 0:
+		case determinismFlagsWithValue[arg]:
+			i++
+			continue
+		case determinismFlags[arg], strings.HasPrefix(arg, "-frandom-seed="):
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// entryDirectory returns the directory of the entries of the workspace, with
+// --directory: the workspace, where the paths Bazel gives relative to the
+// execution root are made absolute, or the execution root, where they are
+// valid as they are. With package, the entries of sources are moved to their
+// package by packageEntry, and the other entries stay in the workspace.
+func entryDirectory() string {
+	switch *directoryFlag {
+	case "workspace", "package":
+		return workspace
+	case "execroot":
+		return executionRoot
+	}
+	panic(fmt.Errorf("invalid --directory %q, expected workspace, execroot or package", *directoryFlag))
+}
+
+// entryFile returns the path of the source file of an entry. Sources of the
+// main repository are relative to the workspace, which the execution root
+// links to. With --directory=execroot they are made absolute in the
+// workspace, so that the entries name the files the editor opens rather than
+// their links in the execution root.
+func entryFile(file string) string {
+	if *directoryFlag == "execroot" && !isAbs(file) {
+		return path.Join(workspace, file)
+	}
+	return file
+}
+
+// packageDir returns the directory of the package of label, in the workspace
+// or in the directory of its external repository.
+func packageDir(label string) string {
+	label = mainRepoLabel(label)
+	i := strings.Index(label, "//")
+	if i < 0 {
+		return workspace
+	}
+	pkg := strings.SplitN(label[i+2:], ":", 2)[0]
+	if i == 0 {
+		return path.Join(workspace, pkg)
+	}
+	return execPath(path.Join("external", strings.TrimLeft(label[:i], "@"), pkg))
+}
+
+// packageEntry returns c, an entry of the workspace, with the package
+// directory dir as its directory, and the paths relative to the workspace
+// made relative to dir, for tools resolving relative includes against the
+// directory of the entry. They become absolute when dir is outside of the
+// workspace, like the packages of external repositories.
+func packageEntry(c compileCommand, dir string) compileCommand {
+	rel := func(p string) string {
+		if isAbs(p) {
+			return p
+		}
+		abs := path.Join(workspace, p)
+		if dir != workspace && !strings.HasPrefix(dir, workspace+"/") {
+			return abs
+		}
+		r, err := filepath.Rel(dir, abs)
+		if err != nil {
+			return abs
+		}
+		return filepath.ToSlash(r)
+	}
+	args := make([]string, 0, len(c.Arguments))
+	for i := 0; i < len(c.Arguments); i++ {
+		arg := c.Arguments[i]
+		if arg == c.File {
+			args = append(args, rel(arg))
+			continue
+		}
+		matched := false
+		for _, f := range pathFlags {
+			switch {
+			case arg == f.flag && i+1 < len(c.Arguments):
+				i++
+				args = append(args, arg, f.mapPath(c.Arguments[i], rel))
+			case f.attached && len(arg) > len(f.flag) && strings.HasPrefix(arg, f.flag):
+				args = append(args, f.flag+f.mapPath(strings.TrimPrefix(arg, f.flag), rel))
+			default:
+				continue
+			}
+			matched = true
+			break
+		}
+		if !matched {
+			args = append(args, arg)
+		}
+	}
+	c.Directory = dir
+	c.Arguments = args
+	c.File = rel(c.File)
+	c.Output = rel(c.Output)
+	return c
+}
+
+// entryPath returns the absolute path of the file of c.
+func entryPath(c compileCommand) string {
+	if isAbs(c.File) {
+		return c.File
+	}
+	return path.Join(c.Directory, c.File)
+}
+
+// workspaceFile returns the path of the file of c relative to the workspace,
+// or "" if it's outside of it.
+func workspaceFile(c compileCommand) string {
+	f := entryPath(c)
+	if !strings.HasPrefix(f, workspace+"/") {
+		return ""
+	}
+	return strings.TrimPrefix(f, workspace+"/")
+}
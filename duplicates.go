@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// sourceCandidate is one way a source file could be compiled: by the target
+// label with args.
+type sourceCandidate struct {
+	label string
+	args  []string
+	// target architecture, if known
+	arch string
+	// exec path of the object file compiled from the source, if known
+	output string
+	// set when args come from an action compiling the source itself, rather
+	// than from another action of the target
+	exact bool
+	// environment variables of the action args come from
+	env map[string]string
+	// the action args come from, if any
+	action *compileAction
+}
+
+// duplicatePolicy chooses the entries emitted for a source file that is
+// compiled by several targets or configurations.
+type duplicatePolicy struct {
+	all bool
+	// prefer targets of the package the source is in
+	pkg    bool
+	prefer *regexp.Regexp
+}
+
+// parseDuplicatePolicy parses the value of --duplicate-sources.
+func parseDuplicatePolicy(v string) duplicatePolicy {
+	switch {
+	case v == "first":
+		return duplicatePolicy{}
+	case v == "all":
+		return duplicatePolicy{all: true}
+	case v == "package":
+		return duplicatePolicy{pkg: true}
+	case strings.HasPrefix(v, "prefer="):
+		re, err := regexp.Compile(strings.TrimPrefix(v, "prefer="))
+		if err != nil {
+			panic(fmt.Errorf("invalid --duplicate-sources regular expression: %s", err))
+		}
+		return duplicatePolicy{prefer: re}
+	}
+	panic(fmt.Errorf("invalid --duplicate-sources %q, expected first, all, package or prefer=<label regex>", v))
+}
+
+// choose returns the candidates to emit entries for file, in the order given.
+// Candidates are ordered by label and then by action, so the choice is
+// deterministic. Candidates with an action of their own for the source are
+// preferred over ones that borrow the arguments of another action.
+func (p duplicatePolicy) choose(file string, candidates []sourceCandidate) []sourceCandidate {
+	if p.all || len(candidates) < 2 {
+		return candidates
+	}
+	best := -1
+	rank := func(c sourceCandidate) int {
+		r := 0
+		if c.exact {
+			r++
+		}
+		if p.pkg && inPackage(file, c.label) {
+			r += 2
+		}
+		if p.prefer != nil && p.prefer.MatchString(apparentLabel(c.label)) {
+			r += 2
+		}
+		return r
+	}
+	for i, c := range candidates {
+		if best < 0 || rank(c) > rank(candidates[best]) {
+			best = i
+		}
+	}
+	return candidates[best : best+1]
+}
+
+// inPackage reports whether file, a path of the database, is in the package
+// of label or one of its subdirectories.
+func inPackage(file string, label string) bool {
+	if !isAbs(file) {
+		file = path.Join(workspace, file)
+	}
+	return strings.HasPrefix(file, packageDir(label)+"/")
+}
+
+// claim records the target whose entry a source compiled by several targets
+// got, and the targets passed over.
+type claim struct {
+	file   string
+	label  string
+	others []string
+}
+
+// newClaim returns the claim of file by the chosen candidate, and false if
+// every candidate is of the same target.
+func newClaim(file string, chosen sourceCandidate, candidates []sourceCandidate) (claim, bool) {
+	c := claim{file: file, label: chosen.label}
+	seen := map[string]bool{chosen.label: true}
+	for _, other := range candidates {
+		if !seen[other.label] {
+			seen[other.label] = true
+			c.others = append(c.others, other.label)
+		}
+	}
+	return c, len(c.others) > 0
+}
+
+// printClaims prints which target each source compiled by several targets
+// got its entry from.
+func printClaims(claims []claim) {
+	if len(claims) == 0 {
+		return
+	}
+	fmt.Printf("%d sources compiled by several targets, claimed by:\n", len(claims))
+	for _, c := range claims {
+		others := make([]string, len(c.others))
+		for i, label := range c.others {
+			others[i] = apparentLabel(label)
+		}
+		fmt.Printf("  %s: %s (over %s)\n", c.file, apparentLabel(c.label), strings.Join(others, ", "))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// emscriptenTarget is the target triple of emcc's clang.
+const emscriptenTarget = "wasm32-unknown-emscripten"
+
+// emccNames are the names of emcc and its wrappers, like the emcc.sh of the
+// toolchain of emsdk's Bazel rules.
+var emccNames = map[string]bool{
+	"emcc":     true,
+	"em++":     true,
+	"emcc.sh":  true,
+	"emcc.py":  true,
+	"emcc.bat": true,
+	"em++.bat": true,
+}
+
+// isEmcc reports whether compiler is emcc.
+func isEmcc(compiler string) bool {
+	return emccNames[compilerName(compiler)]
+}
+
+// emccValueFlags are the emcc flags clang doesn't know that take a value in
+// the next argument.
+var emccValueFlags = map[string]bool{
+	"-s":                true,
+	"--pre-js":          true,
+	"--post-js":         true,
+	"--extern-pre-js":   true,
+	"--extern-post-js":  true,
+	"--js-library":      true,
+	"--embed-file":      true,
+	"--preload-file":    true,
+	"--exclude-file":    true,
+	"--shell-file":      true,
+	"--closure":         true,
+	"--em-config":       true,
+	"--cache":           true,
+	"--source-map-base": true,
+}
+
+// emccFlags are the emcc flags clang doesn't know without a value.
+var emccFlags = map[string]bool{
+	"-gsource-map":          true,
+	"--emrun":               true,
+	"--profiling":           true,
+	"--profiling-funcs":     true,
+	"--memoryprofiler":      true,
+	"--threadprofiler":      true,
+	"--cpuprofiler":         true,
+	"--use-preload-plugins": true,
+}
+
+// emccSettingRegexp matches emcc settings with an attached name, like
+// -sUSE_PTHREADS or -sEXPORTED_FUNCTIONS=_main.
+var emccSettingRegexp = regexp.MustCompile(`^-s[A-Z_]`)
+
+// emscriptenArgs caches the clang and its flags by emcc and EM_BIN_PATH.
+var emscriptenArgs = map[string][]string{}
+
+// translateEmccArgs replaces emcc by the clang of its Emscripten, with the
+// target and sysroot flags emcc adds, and drops the flags only emcc knows.
+func translateEmccArgs(args []string, env map[string]string) []string {
+	out := append([]string{}, emscriptenClang(args[0], env)...)
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case emccValueFlags[arg]:
+			i++
+		case emccFlags[arg], emccSettingRegexp.MatchString(arg):
+		default:
+			if strings.HasPrefix(arg, "--closure=") || strings.HasPrefix(arg, "--em-config=") {
+				continue
+			}
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// emscriptenClang returns the clang emcc runs followed by the flags it adds.
+// These are printed by emcc --cflags, which is run in the execution root
+// with the action's env, as the wrappers of emsdk's Bazel rules find
+// Emscripten through EM_BIN_PATH. Without them, the target and the sysroot
+// of the Emscripten cache are used.
+func emscriptenClang(emcc string, env map[string]string) []string {
+	binPath := env["EM_BIN_PATH"]
+	key := emcc + "\x00" + binPath
+	if args, ok := emscriptenArgs[key]; ok {
+		return args
+	}
+
+	// the Emscripten directory, with the clang of the toolchain in ../bin
+	bin := emcc
+	if !strings.Contains(emcc, "/") {
+		if p, err := exec.LookPath(emcc); err == nil {
+			emcc = toSlash(p)
+		}
+	} else {
+		bin = hostFile(emcc)
+	}
+	emscripten := path.Dir(emcc)
+	if binPath != "" {
+		emscripten = path.Join(binPath, "emscripten")
+	}
+	clang := path.Join(path.Dir(emscripten), "bin", "clang")
+	if _, err := os.Stat(hostFile(clang)); err != nil {
+		clang = "clang"
+	}
+	args := []string{clang}
+
+	out := new(strings.Builder)
+	cmd := exec.Command(bin, "--cflags")
+	cmd.Dir = executionRoot
+	cmd.Stdout = out
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		if k != "PWD" {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	if err := cmd.Run(); err == nil {
+		args = append(args, splitCommand(out.String())...)
+	} else {
+		fmt.Fprintf(os.Stderr, "warning: failed to get the flags of %s: %s\n", emcc, err)
+		args = append(args, "--target="+emscriptenTarget)
+		sysroot := path.Join(emscripten, "cache", "sysroot")
+		if _, err := os.Stat(hostFile(sysroot)); err == nil {
+			args = append(args, "--sysroot="+sysroot)
+		}
+	}
+	emscriptenArgs[key] = args
+	return args
+}
+
+// hostFile returns the path of p, an exec path or absolute path, on the
+// host.
+func hostFile(p string) string {
+	if isAbs(p) {
+		return p
+	}
+	return path.Join(executionRoot, p)
+}
@@ -0,0 +1,54 @@
+package main
+
+import "strings"
+
+// actionEnv returns the environment variables Bazel runs a with, like
+// SDKROOT, DEVELOPER_DIR or ZERO_AR_DATE.
+func actionEnv(a action) map[string]string {
+	if len(a.EnvironmentVariables) == 0 {
+		return nil
+	}
+	env := make(map[string]string, len(a.EnvironmentVariables))
+	for _, kv := range a.EnvironmentVariables {
+		env[kv.Key] = kv.Value
+	}
+	return env
+}
+
+// envPath returns the value of the variable k of env when it names a path,
+// rather than a placeholder the action resolves when it runs.
+func envPath(env map[string]string, k string) string {
+	v := env[k]
+	if strings.Contains(v, "__BAZEL_") {
+		return ""
+	}
+	return v
+}
+
+// envEntry is an entry of the environment sidecar of a database, matched to
+// the database entry by file and output.
+type envEntry struct {
+	Directory   string            `json:"directory"`
+	File        string            `json:"file"`
+	Output      string            `json:"output,omitempty"`
+	Environment map[string]string `json:"environment"`
+}
+
+// envSidecarName returns the name of the environment sidecar of the named
+// database, e.g. compile_commands.env.json.
+func envSidecarName(name string) string {
+	return strings.TrimSuffix(name, ".json") + ".env.json"
+}
+
+// writeEnvSidecar writes the environments of the entries of a database, which
+// compile_commands.json has no field for, to the named file in the workspace.
+func writeEnvSidecar(name string, compileCommands []compileCommand) {
+	entries := []envEntry{}
+	for _, c := range compileCommands {
+		if len(c.env) == 0 {
+			continue
+		}
+		entries = append(entries, envEntry{c.Directory, c.File, c.Output, c.env})
+	}
+	writeJSON(name, entries)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// globRegexp returns the regular expression of the glob pattern g, where *
+// and ? match within a path component and ** matches any number of them.
+func globRegexp(g string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(g); i++ {
+		switch {
+		case strings.HasPrefix(g[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(g[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case g[i] == '*':
+			b.WriteString("[^/]*")
+		case g[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		panic(fmt.Errorf("invalid --exclude-path %q: %s", g, err))
+	}
+	return re
+}
+
+// thirdPartyGlobs are the globs of the conventional directories of vendored
+// code, left out with --skip-third-party.
+var thirdPartyGlobs = []string{"**/third_party/**", "**/vendor/**"}
+
+// excludeEntries returns the entries whose files match none of the globs of
+// --exclude-path. Files in the workspace are matched by their path relative
+// to it, the others by their absolute path. With --skip-third-party, the
+// files of the conventional directories of vendored code and of external
+// repositories are left out too.
+func excludeEntries(compileCommands []compileCommand) []compileCommand {
+	patterns := excludePaths
+	if *skipThirdParty {
+		patterns = append(append([]string{}, patterns...), thirdPartyGlobs...)
+	}
+	if len(patterns) == 0 {
+		return compileCommands
+	}
+	var globs []*regexp.Regexp
+	for _, g := range patterns {
+		globs = append(globs, globRegexp(toSlash(g)))
+	}
+	var out []compileCommand
+	for _, c := range compileCommands {
+		if c.File != "" && matchesAny(globs, entryGlobPath(c)) {
+			continue
+		}
+		if c.File != "" && *skipThirdParty && isExternalPath(entryPath(c)) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// entryGlobPath returns the path of the file of c that globs are matched
+// against.
+func entryGlobPath(c compileCommand) string {
+	if f := workspaceFile(c); f != "" {
+		return f
+	}
+	return entryPath(c)
+}
+
+// matchesAny reports whether p matches any of globs.
+func matchesAny(globs []*regexp.Regexp, p string) bool {
+	for _, re := range globs {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
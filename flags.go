@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// stringList is a flag that may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// commaList is a flag holding a comma separated list. It may be given
+// multiple times.
+type commaList []string
+
+func (l *commaList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *commaList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// mnemonicLanguages is a flag mapping the mnemonics of the compile actions
+// of custom rules to the language of their sources, as Name=lang. It may be
+// given multiple times.
+type mnemonicLanguages struct {
+	names []string
+	langs map[string]string
+}
+
+func (m *mnemonicLanguages) String() string {
+	var s []string
+	for _, n := range m.names {
+		s = append(s, n+"="+m.langs[n])
+	}
+	return strings.Join(s, ",")
+}
+
+func (m *mnemonicLanguages) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected Name=lang, got %q", v)
+	}
+	if _, ok := languagePrecedence[parts[1]]; !ok {
+		return fmt.Errorf("unknown language %q, expected c, c++, objective-c, objective-c++, c++-module or assembler", parts[1])
+	}
+	if m.langs == nil {
+		m.langs = map[string]string{}
+	}
+	if _, ok := m.langs[parts[0]]; !ok {
+		m.names = append(m.names, parts[0])
+	}
+	m.langs[parts[0]] = parts[1]
+	return nil
+}
+
+var universeExpr = flag.String("universe", "//...", "query `expression` of the targets whose compile actions are included, e.g. 'deps(//app:main)'")
+
+var targetsFile = flag.String("targets-file", "", "`file` with one target pattern per line, replacing --universe; lines starting with # are comments and - excludes a pattern")
+
+var includeExecConfiguration = flag.Bool("include-exec-configuration", false, "include compile actions of tools built for the execution platform")
+
+var compilationMode = flag.String("compilation-mode", "fastbuild", "Bazel compilation `mode` whose flags are used: dbg, fastbuild or opt; empty leaves it to the .bazelrc")
+
+var (
+	targetPlatform = flag.String("platform", "", "target platform `label` forwarded as --platforms, e.g. //platforms:rpi4")
+	targetCPU      = flag.String("cpu", "", "target `cpu` forwarded as --cpu, for toolchains that don't use platforms")
+)
+
+var compilerFlag = flag.String("compiler", "action", "compiler `executable` of the entries; action keeps the one of the compile action, resolved to an absolute path")
+
+var unwrapCompilerFlag = flag.Bool("unwrap-compiler", true, "replace the wrapper scripts of Bazel's toolchains, like cc_wrapper.sh and wrapped_clang, with the compiler they call")
+
+var consumer = flag.String("consumer", "clang", "`kind` of tool reading the database; clang drops flags only GCC understands, gcc keeps them")
+
+var clangCompat = flag.String("clang-compat", "", "`path or version` of the clang used by the consumer; arguments it doesn't support are dropped")
+
+var duplicateSources = flag.String("duplicate-sources", "first", "`policy` for sources compiled by several targets: first, all, package, or prefer=<label regex>")
+
+var preferredArch = flag.String("arch", "", "preferred `architecture` for sources compiled for several, e.g. in Apple multi-arch builds (default host architecture)")
+
+var buildMode = flag.String("build", "none", "build before writing: `none`, required to build only the missing generated inputs, or all targets")
+
+var buildForeignCc = flag.Bool("build-foreign-cc", false, "build the install trees of rules_foreign_cc libraries that don't exist yet")
+
+var remoteHeaders = flag.String("remote-headers", "auto", "download generated headers of remote actions: `auto` when --remote_download_minimal or toplevel is configured, always or never")
+
+var extraWorkspaces commaList
+
+func init() {
+	flag.Var(&extraWorkspaces, "workspaces", "comma separated `dirs` of more Bazel workspaces whose entries are merged into this workspace's database")
+}
+
+var (
+	configsFlag   = flag.String("configs", "", "comma separated `name=flags` configurations to generate, e.g. linux=--config=linux,asan=--config=asan")
+	configsOutput = flag.String("configs-output", "merged", "with --configs, write a `merged` database or separate compile_commands.<name>.json files")
+	primaryConfig = flag.String("primary-config", "", "with --configs, the `name` of the configuration preferred in compile_commands.json (default first)")
+)
+
+var (
+	alsoPatterns stringList
+	includeTags  commaList
+	excludeTags  commaList
+	kinds        commaList
+	excludeKinds commaList
+)
+
+func init() {
+	flag.Var(&alsoPatterns, "also", "additional target `pattern` whose compile actions are included, e.g. @myrepo//... (repeatable)")
+	flag.Var(&includeTags, "include-tags", "only include targets with at least one of these comma separated `tags`")
+	flag.Var(&excludeTags, "exclude-tags", "exclude targets with any of these comma separated `tags`, e.g. no-ide,manual")
+	flag.Var(&kinds, "kinds", "only include targets of these comma separated rule `kinds`, e.g. cc_library,cc_binary")
+	flag.Var(&excludeKinds, "exclude-kinds", "exclude targets of these comma separated rule `kinds`, e.g. cc_test")
+}
+
+var swiftOutput = flag.String("swift-output", "", "also write the compile commands of SwiftCompile actions to this `file` in the workspace, e.g. compile_commands.json to add them to the main database")
+
+var linkCommands = flag.Bool("link-commands", false, "also write the link actions to link_commands.json")
+
+var format = flag.String("format", "arguments", "write entries with `arguments` arrays or command strings, or compile_flags to write compile_flags.txt files instead of compile_commands.json")
+
+var keepCompileArgs = flag.Bool("keep-compile-args", false, "keep the -c flag of compile actions, so entries are complete commands")
+
+var externalIsystem = flag.Bool("external-isystem", false, "turn -I flags of external repositories into -isystem")
+
+var iquoteRootsFlag = flag.String("iquote-roots", "never", "add bazel-bin, the execution root and the output base as -iquote directories to `never`, missing (entries without -iquote flags) or always entries")
+
+var resolveVirtualIncludesFlag = flag.Bool("resolve-virtual-includes", false, "replace _virtual_includes directories by the directories of the headers")
+
+var moduleMaps = flag.String("module-maps", "keep", "`keep`, strip, or resolve (drop the missing ones) the module map flags of Clang modules and layering_check")
+
+var pch = flag.String("pch", "substitute", "`substitute` precompiled headers with the headers they are compiled from, or keep them")
+
+var instrumentation = flag.String("instrumentation", "keep", "`keep`, strip, or strip-unknown (GCC-only or naming missing files) the sanitizer, coverage, profiling and LTO flags")
+
+var stripDeterminism = flag.Bool("strip-determinism-flags", true, "drop the per-object determinism and dependency file flags, like -frandom-seed= and -MD -MF")
+
+var resourceDir = flag.String("resource-dir", "auto", "the -resource-dir to add to entries, `auto` to probe the consumer's clang when --compiler or --clang-compat is given, or none")
+
+var systemIncludes = flag.Bool("system-includes", false, "probe the builtin include directories of the actions' compilers and add them to entries")
+
+var fetchToolchains = flag.Bool("fetch-toolchains", false, "fetch the external repositories of compilers that are missing")
+
+var dedupFlagsFlag = flag.Bool("dedup-flags", true, "drop repeated include directories and defines that have no effect")
+
+var actionEnvFlag = flag.Bool("action-env", false, "also write the environment variables of the actions next to each database, e.g. to compile_commands.env.json")
+
+var appleSDKFlag = flag.String("apple-sdk", "", "the `sdk` whose path replaces the SDK placeholder of Apple actions, like iphoneos or iphonesimulator, instead of the one of each action's target")
+
+var developerDir = flag.String("developer-dir", "", "the Xcode developer `directory` to resolve SDKs and tools in, instead of DEVELOPER_DIR or the one of xcode-select")
+
+var xcodeVersion = flag.String("xcode-version", "", "the `version` of the installed Xcode to resolve SDKs and tools in, like Bazel's --xcode_version")
+
+var emscriptenMnemonics commaList
+
+func init() {
+	flag.Var(&emscriptenMnemonics, "emscripten-mnemonics", "comma separated `mnemonics` of the compile actions of custom Emscripten rules, besides CppCompile")
+}
+
+var profilesFlag = flag.String("profiles", "", "JSON `file` of translation profiles for the compilers of more cross toolchains, taking precedence over the built-in ones")
+
+var customMnemonics mnemonicLanguages
+
+func init() {
+	flag.Var(&customMnemonics, "mnemonic", "`Name=lang` of the compile actions of custom rules to extract with the language of -x, e.g. MyRuleCompile=c; may be repeated")
+}
+
+var pathMaps stringList
+
+func init() {
+	flag.Var(&pathMaps, "path-map", "`from=to` replacing the root from of every emitted path by to, e.g. /container/root=/host/root; may be repeated")
+}
+
+var pathMapFile = flag.String("path-map-file", "", "`file` of from=to rules like the ones of --path-map, one per line, relative to the workspace; lines starting with # are comments")
+
+var relativePaths = flag.Bool("relative-paths", false, "write the paths under the directory of each entry relative to it")
+
+var portable = flag.Bool("portable", false, "write the execution root, output base and workspace as ${EXEC_ROOT}, ${OUTPUT_BASE} and ${WORKSPACE}, to be replaced on each machine by the instantiate command")
+
+var canonicalizeSources = flag.String("canonicalize-sources", "off", "path sources reached through symlinks are emitted under: `off`, realpath or workspace-relative")
+
+var convenienceSymlinks = flag.Bool("convenience-symlinks", false, "write paths into the execution root through the convenience symlinks of the workspace, like bazel-out, so the database survives changes of the output base")
+
+var directoryFlag = flag.String("directory", "workspace", "directory of the entries: the `workspace`, with the paths Bazel gives relative to the execution root made absolute, execroot, where they stay relative, or package, the package of the source")
+
+var headerOnlyTargets = flag.Bool("header-only-targets", true, "give the headers of targets without compile actions, like header-only cc_library targets whose headers no other entry covers, entries with the includes and defines of their CcInfo")
+
+var clangdFallback = flag.Bool("clangd-fallback", false, "write a .clangd file giving the files without entries the include directories and defines of most entries")
+
+var coverageReport = flag.String("coverage", "", "write the sources and headers of the workspace without entries, grouped by directory, to `file` in the workspace, or to stdout with -")
+
+var targetsJSON = flag.Bool("targets-json", false, "also write targets.json, mapping each file of compile_commands.json to the labels, mnemonics and configurations of the targets compiling it")
+
+var includeExternalSources = flag.Bool("include-external-sources", false, "also include the compile actions of the targets of external repositories the universe depends on")
+
+var symlinkFarm = flag.Bool("symlink-farm", false, "write the paths of bazel-out and of external repositories through symlinks in .compile_commands in the workspace, for tools refusing paths outside of the project")
+
+var onlyTests = flag.Bool("only-tests", false, "only include the test targets of the universe and the targets of the universe they depend on")
+
+var noTests = flag.Bool("no-tests", false, "leave out the test targets of the universe")
+
+var excludePaths stringList
+
+func init() {
+	flag.Var(&excludePaths, "exclude-path", "`glob` of the files whose entries are left out, relative to the workspace, where ** matches any number of directories, e.g. 'third_party/**'; may be repeated")
+}
+
+var skipThirdParty = flag.Bool("skip-third-party", false, "leave out the entries of files in third_party and vendor directories and in external repositories")
+
+var sourcesFlag = flag.String("sources", "direct", "sources of each target: the `direct` sources and headers it names, or transitive, also the ones of its dependencies")
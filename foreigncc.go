@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// foreignCcMnemonics matches the mnemonics of the actions that run the
+// external build systems of rules_foreign_cc, e.g. CcCmakeMakeRule.
+const foreignCcMnemonics = "Cc.*MakeRule"
+
+// foreignCcInstallDirs returns the install trees produced by the
+// rules_foreign_cc targets in the dependencies of the universe, keyed by exec
+// path, with the label of the target producing each. Headers of these
+// libraries are only in the install trees, which exist after a build.
+func foreignCcInstallDirs(cfg bazelConfig) map[string]string {
+	g := aquery(cfg, fmt.Sprintf(`mnemonic("%s", deps(%s))`, foreignCcMnemonics, universe()))
+	dirs := map[string]string{}
+	for _, a := range g.Actions {
+		label, ok := g.label(a)
+		if !ok {
+			continue
+		}
+		for _, id := range a.OutputIDs {
+			if g.artifacts[id].IsTreeArtifact {
+				dirs[g.artifactPath(id)] = label
+			}
+		}
+	}
+	return dirs
+}
+
+// checkForeignCcOutputs makes sure the install trees of rules_foreign_cc
+// libraries exist, since the include flags of their dependents point into
+// them. Missing trees are built with --build-foreign-cc, and otherwise
+// reported.
+func checkForeignCcOutputs(cfg bazelConfig) {
+	dirs := foreignCcInstallDirs(cfg)
+	missing := map[string]bool{}
+	for dir, label := range dirs {
+		if _, err := os.Stat(path.Join(executionRoot, dir)); err != nil {
+			missing[label] = true
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	labels := make([]string, 0, len(missing))
+	for label := range missing {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	if !*buildForeignCc {
+		fmt.Fprintf(os.Stderr, "warning: %d rules_foreign_cc libraries aren't built, their headers won't resolve until they are (or use --build-foreign-cc)\n", len(labels))
+		return
+	}
+	fmt.Printf("building %d rules_foreign_cc libraries\n", len(labels))
+	args := append([]string{"build", "--keep_going"}, analysisFlags(cfg)...)
+	cmd := bazelCommand(append(append(args, "--"), labels...)...)
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to build rules_foreign_cc libraries: %s\n", err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+// formatEntries returns the entries of a database in the form of --format:
+// with `arguments` arrays, or with `command` strings for consumers that only
+// understand those. With compile_flags, the databases other than the one
+// written as compile_flags.txt files keep their arrays.
+func formatEntries(compileCommands []compileCommand) []compileCommand {
+	switch *format {
+	case "arguments", "compile_flags":
+		return compileCommands
+	case "command":
+		formatted := make([]compileCommand, len(compileCommands))
+		for i, c := range compileCommands {
+			c.Command = joinCommand(c.Arguments)
+			c.Arguments = nil
+			formatted[i] = c
+		}
+		return formatted
+	}
+	panic(fmt.Errorf("invalid --format %q, expected arguments, command or compile_flags", *format))
+}
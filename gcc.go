@@ -0,0 +1,90 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// gccOnlyFlags maps flags only GCC understands to their clang equivalent, or
+// to "" if clang has none and the flag is dropped.
+var gccOnlyFlags = map[string]string{
+	"-fconserve-stack":                   "",
+	"-fdiagnostics-color":                "-fcolor-diagnostics",
+	"-fno-allow-store-data-races":        "",
+	"-fno-canonical-system-headers":      "",
+	"-fno-code-hoisting":                 "",
+	"-fno-ipa-cp-clone":                  "",
+	"-fno-ipa-sra":                       "",
+	"-fno-lifetime-dse":                  "",
+	"-fno-tree-loop-distribute-patterns": "",
+	"-fno-var-tracking-assignments":      "",
+	"-fstack-usage":                      "",
+	"-fvar-tracking-assignments":         "",
+	"-Wno-maybe-uninitialized":           "-Wno-uninitialized",
+}
+
+// gccOnlyFlagPrefixes are the prefixes of GCC-only flags taking a value,
+// which are dropped.
+var gccOnlyFlagPrefixes = []string{
+	"-fcallgraph-info",
+	"-fdump-",
+	"-fplugin=",
+	"-fplugin-arg-",
+	"-mbranch-protection=",
+	"-mfunction-return=",
+	"-mindirect-branch=",
+	"-specs=",
+}
+
+// gccNameRegexp matches the names of the GCC drivers, as returned by
+// compilerName, including the ones of cross toolchains like
+// aarch64-linux-gnu-g++-12.
+var gccNameRegexp = regexp.MustCompile(`^(.+-)?(gcc|g\+\+)(-[\d.]+)?$`)
+
+// isGCC reports whether compiler, the compiler of an action, is GCC, or the
+// wrapper script of Bazel's toolchains calling it.
+func isGCC(compiler string) bool {
+	switch compilerName(compiler) {
+	case "cc_wrapper.sh", "cc_wrapper":
+		compiler = unwrapCompiler(compiler)
+	}
+	return gccNameRegexp.MatchString(compilerName(compiler))
+}
+
+// translateGCCFlags drops or replaces the flags of GCC toolchains that make
+// clang based tools fail, when the consumer of the database is clang based.
+// The actions of clang toolchains are left alone, as clang understands some
+// of these flags, like -mbranch-protection= on arm64.
+func translateGCCFlags(a *compileAction, args []string) []string {
+	if *consumer != "clang" || !isGCC(a.compiler) {
+		return args
+	}
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i == 0 {
+			out = append(out, arg)
+			continue
+		}
+		if replacement, ok := gccOnlyFlags[arg]; ok {
+			if replacement != "" {
+				out = append(out, replacement)
+			}
+			continue
+		}
+		if hasAnyPrefix(arg, gccOnlyFlagPrefixes) {
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// hasAnyPrefix reports whether s starts with any of prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
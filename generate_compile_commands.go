@@ -6,190 +6,612 @@ import (
 	"bufio"
 	_ "embed"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
 )
 
-// These two types are a minimal subset of the types from
-// https://github.com/bazelbuild/bazel/blob/68e14b553e746655b71aaa59b766b659888f08b6/src/main/protobuf/analysis.proto
-// The identifiers are encoded as json ints, not strings. Not sure if that's
-// a bug or not.
-
-type actionGraphContainer struct {
-	Targets       []target
-	Actions       []action
-	DepSetOfFiles []depSetOfFiles
+// bazelVersion is a parsed `bazel --version`.
+type bazelVersion struct {
+	raw                 string
+	major, minor, patch int
 }
 
-type target struct {
-	ID    int `json:"id"`
-	Label string
+func (v bazelVersion) String() string {
+	return v.raw
 }
 
-type action struct {
-	TargetID        int `json:"targetId"`
-	ConfigurationID int `json:"configurationId"`
-	Mnemonic        string
-	Arguments       []string
+// less reports whether v is older than major.minor.
+func (v bazelVersion) less(major, minor int) bool {
+	return v.major < major || (v.major == major && v.minor < minor)
 }
 
-type depSetOfFiles struct {
-	ID                int `json:"id"`
-	DirectArtifactIds []int
-}
+// The oldest Bazel release that supports `aquery --output=jsonproto` with
+// the mnemonic() filter.
+const (
+	minBazelMajor = 4
+	minBazelMinor = 0
+)
+
+// running Bazel version
+var bazel bazelVersion
 
 // type derived from compile_commands.json format
 
 type compileCommand struct {
 	Directory string   `json:"directory"`
-	Arguments []string `json:"arguments"`
-	File      string   `json:"file"`
+	Arguments []string `json:"arguments,omitempty"`
+	Command   string   `json:"command,omitempty"`
+	File      string   `json:"file,omitempty"`
+	Output    string   `json:"output,omitempty"`
+
+	// environment of the action, written to the sidecar of --action-env
+	env map[string]string
+	// label of the target of the entry, and the action its arguments come
+	// from, if any
+	label  string
+	action *compileAction
 }
 
 // internal types
 
 type ccTarget struct {
-	srcs  []string
-	args  []string
-	label string
+	srcs []string
+	args []string
+	// the action args come from
+	action *compileAction
+	// environment variables of the action args come from
+	env map[string]string
+	// language of the action args come from, as named by -x
+	language string
+	label    string
+	// compile actions of the target, keyed by the exec path of their source
+	actions map[string][]*compileAction
+	// set when the target has no compile actions, and args come from its
+	// CcInfo
+	headerOnly bool
+}
+
+type compileAction struct {
+	mnemonic string
+	// the action's own compiler, before --compiler
+	compiler string
+	// mnemonic of the configuration, e.g. k8-fastbuild
+	configuration string
+	arch          string
+	src           string
+	output        string
+	args          []string
+	// language of src, as named by -x
+	language string
+	// exec paths of the inputs generated by other actions
+	generatedInputs []string
+	// set when src is a tree artifact, a directory of generated sources
+	tree bool
+	// names of the argument rewrites that changed args
+	rewrites []string
+	// changes of the argument rewrites of argRewrites, in order
+	changes []argChange
+	// environment variables of the action
+	env map[string]string
+}
+
+// pathFlag is a compiler flag that takes a path, either as the next argument
+// or, when attached is set, appended to the flag itself.
+type pathFlag struct {
+	flag     string
+	attached bool
+}
+
+// namedPathFlags are the pathFlags whose path may be preceded by a name, as
+// in -fmodule-file=<name>=<path>.
+var namedPathFlags = map[string]bool{
+	"-fmodule-file=": true,
+}
+
+// resolve returns v, the value of f, with its path resolved by execPath.
+func (f pathFlag) resolve(v string) string {
+	return f.mapPath(v, execPath)
+}
+
+// mapPath returns v, the value of f, with its path mapped by m.
+func (f pathFlag) mapPath(v string, m func(string) string) string {
+	if i := strings.Index(v, "="); namedPathFlags[f.flag] && i >= 0 {
+		return v[:i+1] + m(v[i+1:])
+	}
+	return m(v)
+}
+
+// pathFlags are the gnu-style flags that take a path.
+var pathFlags = []pathFlag{
+	{"-I", true},
+	{"-iquote", true},
+	{"-isystem", true},
+	{"-idirafter", true},
+	{"-F", true},
+	{"-iframework", true},
+	{"-include", false},
+	{"-imacros", false},
+	{"-include-pch", false},
+	{"-isysroot", true},
+	{"--sysroot=", true},
+	{"--sysroot", false},
+	{"-fmodule-map-file=", true},
+	{"-fmodule-output=", true},
+	{"-fmodule-file=", true},
+	{"-ivfsoverlay", false},
+	{"-o", false},
+	{"-MF", false},
 }
 
 //go:embed src_paths.cquery.bzl
 var srcPathsCquerySrc []byte
 
+//go:embed incompatible.cquery.bzl
+var incompatibleCquerySrc []byte
+
 // current workspace directory
 var workspace string = os.Getenv("BUILD_WORKSPACE_DIRECTORY")
 
-func getBazelInfo(v string) string {
-	out := new(strings.Builder)
-	cmd := exec.Command("bazel", "info", "workspace")
-	cmd.Stdout = out
+// Bazel output directories
+var (
+	executionRoot string
+	outputBaseDir string
+	// bin directory of the configuration being generated
+	binDir string
+)
+
+// Xcode developer directory substituted for Bazel's placeholder on macOS
+var xcodeDeveloperDir string
+
+// bazelCommand returns a bazel command run in the workspace.
+func bazelCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(bazelBinary(), queryFileArgs(args)...)
 	cmd.Stderr = os.Stderr
 	cmd.Dir = workspace
-	if err := cmd.Run(); err != nil {
-		panic(fmt.Errorf("could not get %q: %s", v, err))
-	}
-	return strings.TrimSpace(out.String())
+	return cmd
 }
 
-func getXcodeSDKPath(dir string, sdk string) string {
+// getBazelInfo returns the value of a `bazel info` key. Build flags are only
+// needed for keys that depend on the configuration, like bazel-bin.
+func getBazelInfo(v string, flags ...string) string {
 	out := new(strings.Builder)
-	cmd := exec.Command("xcrun", "--sdk", sdk, "--show-sdk-path")
+	cmd := bazelCommand(append([]string{"info", v}, flags...)...)
 	cmd.Stdout = out
-	cmd.Stderr = os.Stderr
-	cmd.Dir = dir
 	if err := cmd.Run(); err != nil {
-		panic(fmt.Errorf("could not get sdk path: %s", err))
+		panic(fmt.Errorf("could not get %q: %s", v, err))
 	}
-	return strings.TrimSpace(out.String())
+	return hostPath(strings.TrimSpace(out.String()))
 }
 
-func getXcodeDeveloperDir(dir string) string {
+func getBazelVersion() bazelVersion {
 	out := new(strings.Builder)
-	cmd := exec.Command("xcode-select", "-p")
+	cmd := exec.Command(bazelBinary(), "--version")
 	cmd.Stdout = out
 	cmd.Stderr = os.Stderr
-	cmd.Dir = dir
+	cmd.Dir = workspace
 	if err := cmd.Run(); err != nil {
-		panic(fmt.Errorf("could not get xcode developer directory: %s", err))
+		panic(fmt.Errorf("could not get Bazel version: %s", err))
+	}
+	v := bazelVersion{
+		raw: strings.TrimPrefix(strings.TrimSpace(out.String()), "bazel "),
+	}
+	if v.raw == "no_version" {
+		// development build, assume it's recent
+		v.major = math.MaxInt32
+		return v
+	}
+	if _, err := fmt.Sscanf(v.raw, "%d.%d.%d", &v.major, &v.minor, &v.patch); err != nil {
+		panic(fmt.Errorf("could not parse Bazel version %q: %s", v.raw, err))
+	}
+	return v
+}
+
+// execPath returns the path to use in the compilation database for p, a
+// path relative to the execution root. Files of external repositories are
+// resolved under the output base, using their canonical repository
+// directory, and generated files and sibling repositories (../<repo>) under
+// the execution root. Sources of the main repository stay relative to the
+// workspace.
+func execPath(p string) string {
+	p = msysPath(toSlash(p))
+	if isAbs(p) {
+		return p
+	}
+	switch strings.SplitN(path.Clean(p), "/", 2)[0] {
+	case "external":
+		return externalPath(p)
+	case "bazel-out", "..":
+		return path.Join(executionRoot, p)
+	}
+	return p
+}
+
+// rewritePaths resolves the paths in args with execPath. Paths are the
+// values of flags and arguments that name an artifact of g, including the
+// ones swiftc passes on to clang with -Xcc, like -Xcc -F -Xcc <dir>.
+func (g *actionGraph) rewritePaths(args []string, flags []pathFlag) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-Xcc" && i+1 < len(args) {
+			var xcc []string
+			for ; i+1 < len(args) && args[i] == "-Xcc"; i += 2 {
+				xcc = append(xcc, args[i+1])
+			}
+			i--
+			for _, a := range g.rewritePaths(xcc, flags) {
+				out = append(out, "-Xcc", a)
+			}
+			continue
+		}
+		if g.isArtifact(arg) {
+			out = append(out, execPath(arg))
+			continue
+		}
+		matched := false
+		for _, f := range flags {
+			switch {
+			case arg == f.flag && i+1 < len(args):
+				i++
+				out = append(out, arg, f.resolve(args[i]))
+			case f.attached && len(arg) > len(f.flag) && strings.HasPrefix(arg, f.flag):
+				out = append(out, f.flag+f.resolve(strings.TrimPrefix(arg, f.flag)))
+			default:
+				continue
+			}
+			matched = true
+			break
+		}
+		if !matched {
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// substituteXcodePlaceholders replaces the placeholders of the Xcode paths
+// in args, which Apple toolchains resolve when actions run. The SDKROOT and
+// DEVELOPER_DIR of the action's env win over those of the host, which are
+// only known on macOS, where the SDK is the one of the action's platform.
+func substituteXcodePlaceholders(args []string, env map[string]string) []string {
+	sdk, developerDir := envPath(env, "SDKROOT"), xcodeDeveloperDir
+	if sdk == "" && runtime.GOOS == "darwin" && hasXcodeSDKPlaceholder(args) {
+		sdk = xcodeSDKPath(appleSDK(args, env))
+	}
+	if p := envPath(env, "DEVELOPER_DIR"); p != "" {
+		developerDir = p
 	}
-	return strings.TrimSpace(out.String())
+	for i, arg := range args {
+		if sdk != "" {
+			arg = strings.ReplaceAll(arg, "__BAZEL_XCODE_SDKROOT__", sdk)
+		}
+		if developerDir != "" {
+			arg = strings.ReplaceAll(arg, "__BAZEL_XCODE_DEVELOPER_DIR__", developerDir)
+		}
+		args[i] = arg
+	}
+	return args
+}
+
+// hasXcodeSDKPlaceholder reports whether args refer to the SDK of the action.
+func hasXcodeSDKPlaceholder(args []string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, "__BAZEL_XCODE_SDKROOT__") {
+			return true
+		}
+	}
+	return false
 }
 
 func main() {
-	// determine the workspace path if it's not set already
-	if workspace == "" {
+	flag.Parse()
+	defer removeQueryFiles()
+
+	// determine the workspace path if it's not set already, without running
+	// Bazel for the flags command
+	if workspace == "" && flag.Arg(0) == "flags" {
+		workspace = findWorkspace()
+	} else if workspace == "" {
 		workspace = getBazelInfo("workspace")
 	}
-	executionRoot := getBazelInfo("execution_root")
-	outputBaseDir := getBazelInfo("output_base")
-	binDir := getBazelInfo("bazel-bin")
+	root := workspace
+	switch flag.Arg(0) {
+	case "":
+	case "instantiate":
+		setWorkspace(root)
+		instantiate(flag.Args()[1:])
+		return
+	case "files":
+		files(root, flag.Args()[1:])
+		return
+	case "explain":
+		explain(root, flag.Args()[1:])
+		return
+	case "flags":
+		flagsOf(flag.Args()[1:])
+		return
+	case "owner":
+		owner(root, flag.Args()[1:])
+		return
+	default:
+		panic(fmt.Errorf("unknown command %q", flag.Arg(0)))
+	}
+
+	databases := generateDatabases(root)
+	for i, db := range mapDatabases(databases) {
+		commands := db.commands
+		if *format == "compile_flags" && i == 0 {
+			writeCompileFlags(commands)
+		} else {
+			writeCompileCommands(db.name, commands)
+		}
+		if *actionEnvFlag {
+			writeEnvSidecar(envSidecarName(db.name), commands)
+		}
+		if *clangdFallback && i == 0 {
+			writeClangdConfig(commands)
+		}
+		if *targetsJSON && i == 0 {
+			writeTargetsSidecar("targets.json", commands)
+		}
+	}
+	if *coverageReport != "" {
+		writeCoverageReport(*coverageReport, databases)
+	}
+}
+
+// generateDatabases generates the compilation databases of the workspace
+// root and of the workspaces of --workspaces, which may have the same names.
+func generateDatabases(root string) []database {
+	if *profilesFlag != "" {
+		loadTranslationProfiles(*profilesFlag)
+	}
+	var databases []database
+	var rootState *workspaceState
+	for _, dir := range workspaceRoots(root) {
+		if dir != root {
+			fmt.Printf("workspace %s\n", dir)
+		}
+		setWorkspace(dir)
+		dbs := generateWorkspace()
+		for i := range dbs {
+			dbs[i].commands = excludeEntries(dbs[i].commands)
+		}
+		state := saveWorkspace()
+		if rootState == nil {
+			rootState = state
+		}
+		for i := range dbs {
+			dbs[i].ws = state
+		}
+		databases = append(databases, dbs...)
+	}
+	restoreWorkspace(rootState)
+	return databases
+}
+
+// generateWorkspace generates the compilation databases of the current
+// workspace.
+func generateWorkspace() []database {
+	bazel = getBazelVersion()
+	if bazel.less(minBazelMajor, minBazelMinor) {
+		panic(fmt.Errorf(
+			"unsupported Bazel version %q, %d.%d or newer is required",
+			bazel, minBazelMajor, minBazelMinor,
+		))
+	}
+
+	executionRoot = getBazelInfo("execution_root")
+	outputBaseDir = getBazelInfo("output_base")
+	loadRepoMapping()
 
-	var xcodeSDKPath string
-	var xcodeDeveloperDir string
 	switch runtime.GOOS {
 	case "darwin":
-		xcodeSDKPath = getXcodeSDKPath(executionRoot, "macosx")
 		xcodeDeveloperDir = getXcodeDeveloperDir(executionRoot)
 	}
 
-	targetLabels := map[int]string{}
-	ccTargets := map[string]*ccTarget{}
+	configs := parseConfigs(*configsFlag)
+	databases := generateConfigs(configs)
+	if *swiftOutput != "" {
+		databases = appendDatabases(databases, []database{
+			{name: *swiftOutput, commands: generateSwift(primaryBazelConfig(configs))},
+		})
+	}
+	if *linkCommands {
+		databases = appendDatabases(databases, []database{
+			{name: "link_commands.json", commands: generateLinks(primaryBazelConfig(configs))},
+		})
+	}
+	return databases
+}
 
-	queryMnemonic := func(n string) {
+// generateConfigs generates the compilation databases of configs, or the
+// single database of the default configuration if there are none.
+func generateConfigs(configs []bazelConfig) []database {
+	if len(configs) == 0 {
+		return []database{{name: "compile_commands.json", commands: generate(bazelConfig{})}}
+	}
 
-		out := new(strings.Builder)
-		cmd := exec.Command(
-			"bazel",
-			"aquery",
-			fmt.Sprintf(`mnemonic("%s", //...)`, n),
-			"--output=jsonproto",
-		)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = out
-		cmd.Dir = workspace
+	primary := primaryBazelConfig(configs).name
+	results := map[string][]compileCommand{}
+	for _, cfg := range configs {
+		fmt.Printf("configuration %s: %s\n", cfg.name, strings.Join(cfg.flags, " "))
+		results[cfg.name] = generate(cfg)
+	}
 
-		if err := cmd.Run(); err != nil {
-			panic(fmt.Errorf("failed to run Bazel: %s", err))
+	switch *configsOutput {
+	case "separate":
+		var databases []database
+		for _, cfg := range configs {
+			databases = append(databases, database{
+				name:     fmt.Sprintf("compile_commands.%s.json", cfg.name),
+				commands: results[cfg.name],
+			})
 		}
+		return append(databases, database{name: "compile_commands.json", commands: results[primary]})
+	case "merged":
+		return []database{{name: "compile_commands.json", commands: mergeConfigs(configs, primary, results)}}
+	}
+	panic(fmt.Errorf("invalid --configs-output %q, expected separate or merged", *configsOutput))
+}
 
-		var container actionGraphContainer
-		if err := json.Unmarshal([]byte(out.String()), &container); err != nil {
-			panic(fmt.Errorf("failed to parse aquery output: %s", err))
-		}
+// generate collects the compile commands of the universe in the given
+// configuration.
+func generate(cfg bazelConfig) []compileCommand {
+	binDir = getBazelInfo("bazel-bin", analysisFlags(cfg)...)
 
-		for _, target := range container.Targets {
-			targetLabels[target.ID] = target.Label
-		}
+	ccTargets := map[string]*ccTarget{}
 
-		for _, action := range container.Actions {
+	var templates []actionTemplate
+	// whether actions compile against tree artifacts, like the install trees
+	// of rules_foreign_cc
+	var treeInputs bool
+	queryMnemonic := func(n string) {
+		g := aquery(cfg, fmt.Sprintf(`mnemonic("%s", %s)`, n, withSharedLibraryDeps(universe())))
+		for _, action := range g.Actions {
 			if action.Mnemonic != n {
 				continue
 			}
-			label, ok := targetLabels[action.TargetID]
+			label, ok := g.label(action)
 			if !ok {
-				panic(fmt.Errorf("missing label (%d) in aquery output", action.TargetID))
+				fmt.Fprintf(os.Stderr, "skipping %s action %q of missing target (%d)\n", n, action.ActionKey, action.TargetID)
+				continue
+			}
+			if isIgnoredLabel(label) {
+				continue
+			}
+			conf := g.configuration(action)
+			if isExecConfiguration(conf) && !*includeExecConfiguration {
+				continue
+			}
+			env := actionEnv(action)
+			if len(action.Arguments) == 0 {
+				// action templates, like the CppCompileActionTemplate of
+				// tree artifact sources, have no command line of their own
+				if src, output := g.templateArtifacts(action); src != "" {
+					templates = append(templates, actionTemplate{
+						label:         label,
+						mnemonic:      n,
+						configuration: conf.Mnemonic,
+						src:           src,
+						output:        output,
+						env:           env,
+
+						generatedInputs: g.generatedInputs(action),
+					})
+				}
+				continue
 			}
-			var args []string
-			switch n {
-			case "ObjcCompile":
-				args = []string{"clang", "-xobjective-c++"}
-			case "CppCompile":
-				args = []string{action.Arguments[0], "-xc++"}
+			var args, rewrites []string
+			arguments := stripLaunchers(g.expandParamFiles(action))
+			if isEmcc(arguments[0]) {
+				arguments = translateEmccArgs(arguments, env)
+				rewrites = append(rewrites, "emscripten")
 			}
-			for i := 1; i < len(action.Arguments); i++ {
-				arg := action.Arguments[i]
-				switch {
-				case arg == "-c":
+			if sandboxed := stripSandboxPaths(arguments); !equalArgs(sandboxed, arguments) {
+				arguments = sandboxed
+				rewrites = append(rewrites, "sandbox-paths")
+			}
+			if isMSVC(arguments[0]) {
+				arguments = translateMSVCArgs(arguments)
+				rewrites = append(rewrites, "msvc-flags")
+			}
+			clangCL := isClangCL(arguments[0])
+			args = []string{compiler(arguments[0])}
+			var src string
+			for i := 1; i < len(arguments); i++ {
+				arg := arguments[i]
+				if (arg == "-c" || clangCL && arg == "/c") && i+1 < len(arguments) {
 					i++
+					src = onDiskCase(arguments[i])
+					if *keepCompileArgs {
+						// the source is appended to every entry
+						args = append(args, arg)
+					}
 					continue
-				case strings.HasPrefix(arg, "-Ibazel-out"):
-					arg = "-I" + path.Join(outputBaseDir, strings.TrimPrefix(arg, "-I"))
-				case strings.HasPrefix(arg, "external/") ||
-					strings.HasPrefix(arg, "bazel-out"):
-					arg = path.Join(outputBaseDir, arg)
-				}
-				switch runtime.GOOS {
-				case "darwin":
-					arg = strings.ReplaceAll(arg, "__BAZEL_XCODE_SDKROOT__", xcodeSDKPath)
-					arg = strings.ReplaceAll(arg, "__BAZEL_XCODE_DEVELOPER_DIR__", xcodeDeveloperDir)
 				}
 				args = append(args, arg)
 			}
-			ccTargets[label] = &ccTarget{
-				args: args,
+			// Objective-C toolchains may build every source as
+			// Objective-C++, which breaks plain C-style code in .m files
+			if lang, ok := objcLanguages[path.Ext(src)]; ok && n == "ObjcCompile" {
+				args = setLanguage(args, lang)
+			}
+			// sources of custom rules may have any extension
+			if lang, ok := customMnemonics.langs[n]; ok && actionLanguage(n, args, src) != lang {
+				args = setLanguage(args, lang)
+			}
+			flags := pathFlags
+			if clangCL {
+				flags = append(clangCLPathFlags, pathFlags...)
+			}
+			args = substituteXcodePlaceholders(g.rewritePaths(args, flags), env)
+			var output string
+			if action.PrimaryOutputID != 0 {
+				output = g.artifactPath(action.PrimaryOutputID)
+			}
+			a := &compileAction{
+				mnemonic:      n,
+				compiler:      compilerPath(arguments[0]),
+				configuration: conf.Mnemonic,
+				arch:          actionArch(args, conf.Mnemonic),
+				src:           src,
+				output:        output,
+				language:      actionLanguage(n, args, src),
+
+				generatedInputs: g.generatedInputs(action),
+				tree:            g.isTreeArtifact(src),
+				rewrites:        rewrites,
+				env:             env,
+			}
+			a.args = rewriteArgs(a, args)
+			for _, in := range a.generatedInputs {
+				treeInputs = treeInputs || g.isTreeArtifact(in)
+			}
+			t, ok := ccTargets[label]
+			if !ok {
+				t = &ccTarget{
+					label:    label,
+					args:     a.args,
+					action:   a,
+					env:      a.env,
+					language: a.language,
+					actions:  map[string][]*compileAction{},
+				}
+				ccTargets[label] = t
+			}
+			if languagePrecedence[a.language] > languagePrecedence[t.language] {
+				// headers of targets mixing languages, like .c and .cc
+				// sources, may be included from both, and take the
+				// arguments of an action of the language parsing them
+				t.language = a.language
+				t.args = a.args
+				t.action = a
+				t.env = a.env
 			}
+			t.actions[src] = append(t.actions[src], a)
 		}
 	}
 
-	queryMnemonic("CppCompile")
-	queryMnemonic("ObjcCompile")
+	mnemonics := []string{"CppCompile", "CppCompileActionTemplate", "ObjcCompile", "Cpp20ModuleCompile"}
+	mnemonics = append(append(mnemonics, emscriptenMnemonics...), customMnemonics.names...)
+	queried := map[string]bool{}
+	for _, n := range mnemonics {
+		if !queried[n] {
+			queried[n] = true
+			queryMnemonic(n)
+		}
+	}
+	addTemplateActions(ccTargets, templates)
 
 	labels := make(sort.StringSlice, len(ccTargets))
 	{
@@ -207,43 +629,50 @@ func main() {
 		panic(fmt.Errorf("failed to create temporary directory: %s", err))
 	}
 	defer os.RemoveAll(tmpDir)
-	cqueryPath := path.Join(tmpDir, "src_cquery.bzl")
-	if err := os.WriteFile(cqueryPath, srcPathsCquerySrc, 0777); err != nil {
+	cqueryPath := filepath.Join(tmpDir, "src_cquery.bzl")
+	if err := os.WriteFile(cqueryPath, srcPathsCquerySrc, 0644); err != nil {
+		panic(fmt.Errorf("failed to write cquery file: %s", err))
+	}
+	incompatibleCqueryPath := filepath.Join(tmpDir, "incompatible_cquery.bzl")
+	if err := os.WriteFile(incompatibleCqueryPath, incompatibleCquerySrc, 0644); err != nil {
 		panic(fmt.Errorf("failed to write cquery file: %s", err))
 	}
 
-	scannedSrcs := map[string]bool{}
+	incompatible := queryIncompatibleTargets(cfg, incompatibleCqueryPath)
+	var skipped []string
 	for _, label := range labels {
-		cmd := exec.Command(
-			"bazel",
+		if incompatible[mainRepoLabel(label)] {
+			skipped = append(skipped, label)
+			continue
+		}
+		cmd := bazelCommand(append([]string{
 			"cquery",
-			fmt.Sprintf(`kind("source file", deps(%s))`, label),
+			sourceQuery(label),
 			"--output",
 			"starlark",
 			"--starlark:file",
 			cqueryPath,
-		)
+		}, analysisFlags(cfg)...)...)
 		stderr := new(strings.Builder)
 		stdout := new(strings.Builder)
 		cmd.Stderr = stderr
 		cmd.Stdout = stdout
-		cmd.Dir = workspace
 		if err := cmd.Run(); err != nil {
+			if isIncompatibleError(stderr.String()) {
+				skipped = append(skipped, label)
+				continue
+			}
 			panic(fmt.Errorf("failed to query source paths of %q\n\n%s", label, stderr))
 		}
-		fmt.Println(label)
+		fmt.Println(apparentLabel(label))
 		var srcs []string
 		scn := bufio.NewScanner(strings.NewReader(stdout.String()))
 		for scn.Scan() {
 			txt := scn.Text()
-			if txt == "" {
+			if txt == "" || isIgnored(txt) {
 				continue
 			}
-			if _, ok := scannedSrcs[txt]; ok {
-				continue
-			}
-			scannedSrcs[txt] = true
-			srcs = append(srcs, txt)
+			srcs = append(srcs, onDiskCase(txt))
 		}
 		if err := scn.Err(); err != nil {
 			panic(fmt.Errorf("%s\n\nfailed to parse output of bazel cquery: %s", stderr, err))
@@ -251,33 +680,217 @@ func main() {
 		ccTargets[label].srcs = srcs
 	}
 
-	var compileCommands []compileCommand
+	if *headerOnlyTargets {
+		labels = append(labels, queryHeaderOnlyTargets(cfg, tmpDir, ccTargets, labels, incompatible)...)
+	}
+
+	// the tree artifacts expanded below, and the generated sources, must be
+	// on disk before they're listed
+	buildInputs(cfg, ccTargets, labels)
+	if treeInputs || *buildForeignCc {
+		checkForeignCcOutputs(cfg)
+	}
+
+	switch *remoteHeaders {
+	case "auto":
+		if usesMinimalDownloads(cfg) {
+			downloadGeneratedHeaders(cfg, labels)
+		}
+	case "always":
+		downloadGeneratedHeaders(cfg, labels)
+	case "never":
+	default:
+		panic(fmt.Errorf("invalid --remote-headers %q, expected auto, always or never", *remoteHeaders))
+	}
+
+	// collect every way each source is compiled, in label order, by the
+	// path of the source in the database
+	var srcs []string
+	candidates := map[string][]sourceCandidate{}
 	for _, label := range labels {
 		target := ccTargets[label]
-		for _, src := range target.srcs {
-			compileCommands = append(compileCommands, compileCommand{
-				Directory: workspace,
-				File:      src,
-				Arguments: append(target.args,
-					"-iquote",
-					binDir,
-					"-iquote",
-					executionRoot,
-					"-iquote",
-					outputBaseDir,
-					src,
-				),
-			})
+		for _, src := range append(target.srcs, generatedSources(target)...) {
+			file := canonicalSource(execPath(src))
+			if _, ok := candidates[file]; !ok {
+				srcs = append(srcs, file)
+			} else if target.headerOnly {
+				continue
+			}
+			actions, ok := target.actions[src]
+			if !ok {
+				// sources without an action of their own, like headers, use
+				// the arguments of the target's first action of the
+				// language parsing them
+				candidates[file] = append(candidates[file], sourceCandidate{
+					label:  label,
+					args:   headerArgs(target, src),
+					env:    target.env,
+					action: target.action,
+				})
+				continue
+			}
+			for _, a := range actions {
+				candidates[file] = append(candidates[file], sourceCandidate{
+					label:  label,
+					args:   generatorArgs(a),
+					arch:   a.arch,
+					output: a.output,
+					exact:  true,
+					env:    a.env,
+					action: a,
+				})
+			}
+		}
+		// tree artifacts are compiled by a single action, give each of their
+		// files an entry with its arguments
+		var actionSrcs []string
+		for src := range target.actions {
+			actionSrcs = append(actionSrcs, src)
+		}
+		sort.Strings(actionSrcs)
+		for _, actionSrc := range actionSrcs {
+			for _, a := range target.actions[actionSrc] {
+				if !a.tree {
+					continue
+				}
+				for _, src := range expandTreeArtifact(a.src) {
+					file := canonicalSource(execPath(src))
+					if _, ok := candidates[file]; !ok {
+						srcs = append(srcs, file)
+					}
+					candidates[file] = append(candidates[file], sourceCandidate{
+						label:  label,
+						args:   a.args,
+						arch:   a.arch,
+						exact:  true,
+						env:    a.env,
+						action: a,
+					})
+				}
+			}
+		}
+	}
+
+	policy := parseDuplicatePolicy(*duplicateSources)
+	arch := normalizeArch(*preferredArch)
+	if arch == "" {
+		arch = hostArch()
+	}
+	var compileCommands []compileCommand
+	var claims []claim
+	for _, src := range srcs {
+		file := entryFile(src)
+		chosen := policy.choose(src, preferArch(candidates[src], arch))
+		if len(chosen) == 1 {
+			if c, ok := newClaim(src, chosen[0], candidates[src]); ok {
+				claims = append(claims, c)
+			}
+		}
+		for _, c := range chosen {
+			var output string
+			if c.output != "" {
+				output = execPath(c.output)
+			}
+			entry := compileCommand{
+				Directory: entryDirectory(),
+				File:      file,
+				Output:    output,
+				Arguments: append(append(append([]string{}, c.args...), iquoteRoots(c.args)...), file),
+				env:       c.env,
+				label:     c.label,
+				action:    c.action,
+			}
+			if *directoryFlag == "package" {
+				entry = packageEntry(entry, packageDir(c.label))
+			}
+			compileCommands = append(compileCommands, entry)
+		}
+	}
+
+	writeVirtualIncludesOverlay()
+
+	printClaims(claims)
+	if len(skipped) > 0 {
+		fmt.Printf("skipped %d targets incompatible with the target platform:\n", len(skipped))
+		for _, label := range skipped {
+			fmt.Printf("  %s\n", apparentLabel(label))
 		}
 	}
 
-	content, err := json.MarshalIndent(&compileCommands, "", "  ")
+	return compileCommands
+}
+
+// aquery returns the action graph of the aquery expression in cfg.
+func aquery(cfg bazelConfig, expr string) *actionGraph {
+	args := []string{"aquery", expr, "--output=jsonproto"}
+	if !bazel.less(5, 0) {
+		args = append(args, "--include_param_files")
+	}
+	out := new(strings.Builder)
+	cmd := bazelCommand(append(args, analysisFlags(cfg)...)...)
+	cmd.Stdout = out
+
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to run Bazel: %s", err))
+	}
+
+	var container actionGraphContainer
+	if err := json.Unmarshal([]byte(out.String()), &container); err != nil {
+		panic(fmt.Errorf("failed to parse aquery output of Bazel %s: %s", bazel, err))
+	}
+	return newActionGraph(container)
+}
+
+// queryIncompatibleTargets returns the labels of the targets in the universe
+// that are incompatible with the target platform of cfg, according to their
+// target_compatible_with.
+func queryIncompatibleTargets(cfg bazelConfig, cqueryPath string) map[string]bool {
+	cmd := bazelCommand(append([]string{
+		"cquery",
+		universe(),
+		"--output",
+		"starlark",
+		"--starlark:file",
+		cqueryPath,
+	}, analysisFlags(cfg)...)...)
+	stderr := new(strings.Builder)
+	stdout := new(strings.Builder)
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to query incompatible targets\n\n%s", stderr))
+	}
+	incompatible := map[string]bool{}
+	for _, label := range strings.Split(stdout.String(), "\n") {
+		if label = strings.TrimSpace(label); label != "" {
+			incompatible[mainRepoLabel(label)] = true
+		}
+	}
+	return incompatible
+}
+
+// isIncompatibleError reports whether the stderr of a failed bazel command
+// says that a target is incompatible with the target platform.
+func isIncompatibleError(stderr string) bool {
+	return strings.Contains(stderr, "is incompatible and cannot be built") ||
+		strings.Contains(stderr, "didn't satisfy constraint")
+}
+
+// writeCompileCommands writes a compilation database to the named file in the
+// workspace.
+func writeCompileCommands(name string, compileCommands []compileCommand) {
+	writeJSON(name, formatEntries(compileCommands))
+}
+
+// writeJSON writes v as indented JSON to the named file in the workspace.
+func writeJSON(name string, v interface{}) {
+	content, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		panic(err)
 	}
 
 	err = ioutil.WriteFile(
-		path.Join(workspace, "compile_commands.json"),
+		path.Join(workspace, name),
 		content,
 		0644,
 	)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// generatedSources returns the exec paths of the generated sources t
+// compiles, like the .pb.cc files of protoc, sorted. They aren't source files
+// of the target, so they are only known from its actions. Tree artifacts are
+// expanded separately.
+func generatedSources(t *ccTarget) []string {
+	listed := map[string]bool{}
+	for _, src := range t.srcs {
+		listed[src] = true
+	}
+	var srcs []string
+	for src, actions := range t.actions {
+		if strings.HasPrefix(src, "bazel-out/") && !listed[src] && !actions[0].tree {
+			srcs = append(srcs, src)
+		}
+	}
+	sort.Strings(srcs)
+	return srcs
+}
+
+// generatorSourceRegexp matches the names of the sources generated from a
+// file of their package, which include it or the headers next to it by
+// their name: the outputs of Qt's moc and rcc, of flex and bison, like
+// lexer.yy.cc and parser.tab.cc, and of ragel.
+var generatorSourceRegexp = regexp.MustCompile(`^((moc|qrc)_.+\.(cpp|cc|cxx)|.+\.(yy|tab|lex)\.(c|cc|cpp)|.+\.rl\.(c|cc|cpp))$`)
+
+// generatedIncludeRegexp matches the names of the generated files the
+// sources of a package include by their name, like the ui_*.h headers of
+// Qt's uic, the *.moc files of moc and the headers of bison.
+var generatedIncludeRegexp = regexp.MustCompile(`^(ui_.+\.h|.+\.moc|.+\.tab\.(h|hh|hpp)|location\.hh|position\.hh|stack\.hh)$`)
+
+// generatorArgs returns the arguments of a, with the include directories of
+// the generated sources: the package directory of the file their generator
+// read, and the directory in bazel-out of the generated files the action
+// includes by name. The build finds these through the layout of the
+// sandbox or of the generator's rules, but the editor needs them to
+// resolve the includes.
+func generatorArgs(a *compileAction) []string {
+	var dirs []string
+	if pkg, ok := binPackage(a.src); ok && generatorSourceRegexp.MatchString(path.Base(a.src)) {
+		dirs = append(dirs, pkg)
+	}
+	for _, in := range a.generatedInputs {
+		if generatedIncludeRegexp.MatchString(path.Base(in)) {
+			dirs = append(dirs, execPath(path.Dir(in)))
+		}
+	}
+	if len(dirs) == 0 {
+		return a.args
+	}
+	iquote := "-iquote"
+	if isClangCL(a.args[0]) {
+		iquote = "/I"
+	}
+	args := append([]string{}, a.args...)
+	added := map[string]bool{}
+	for _, arg := range a.args {
+		added[arg] = true
+	}
+	for _, dir := range dirs {
+		if !added[dir] {
+			added[dir] = true
+			args = append(args, iquote, dir)
+		}
+	}
+	return args
+}
+
+// binPackage returns the package directory of p, a generated file under
+// bazel-out/<configuration>/bin, resolved by execPath.
+func binPackage(p string) (string, bool) {
+	parts := strings.SplitN(p, "/", 4)
+	if len(parts) < 4 || parts[0] != "bazel-out" || parts[2] != "bin" {
+		return "", false
+	}
+	return execPath(path.Dir(parts[3])), true
+}
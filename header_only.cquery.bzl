@@ -0,0 +1,19 @@
+# A formatting function for Bazel cquery results
+#
+# Formats the target as JSON with its headers and the flags of its CcInfo, if it has one.
+
+def format(target):
+    p = providers(target) or {}
+    if "CcInfo" not in p:
+        return ""
+    cc = p["CcInfo"].compilation_context
+    local_defines = getattr(cc, "local_defines", None)
+    return json.encode(struct(
+        label = str(target.label),
+        headers = [f.path for f in cc.direct_public_headers + cc.direct_private_headers + getattr(cc, "direct_textual_headers", [])],
+        defines = cc.defines.to_list() + (local_defines.to_list() if local_defines else []),
+        includes = cc.includes.to_list(),
+        quote_includes = cc.quote_includes.to_list(),
+        system_includes = cc.system_includes.to_list(),
+        framework_includes = cc.framework_includes.to_list(),
+    ))
@@ -0,0 +1,132 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed header_only.cquery.bzl
+var headerOnlyCquerySrc []byte
+
+// headerOnlyInfo is the output of header_only.cquery.bzl for a target.
+type headerOnlyInfo struct {
+	Label             string
+	Headers           []string
+	Defines           []string
+	Includes          []string
+	QuoteIncludes     []string `json:"quote_includes"`
+	SystemIncludes    []string `json:"system_includes"`
+	FrameworkIncludes []string `json:"framework_includes"`
+}
+
+// queryHeaderOnlyTargets adds the targets of the universe with headers but
+// no compile actions, like header-only cc_library targets, to ccTargets and
+// returns their labels, sorted. Their headers are only emitted when no target
+// compiling them includes them, as the arguments of a dependent target are
+// better than the ones of the target's CcInfo: its includes and defines, with
+// the compiler, language and language standard of the first target in
+// labels.
+func queryHeaderOnlyTargets(cfg bazelConfig, dir string, ccTargets map[string]*ccTarget, labels []string, incompatible map[string]bool) []string {
+	cqueryPath := filepath.Join(dir, "header_only.cquery.bzl")
+	if err := os.WriteFile(cqueryPath, headerOnlyCquerySrc, 0644); err != nil {
+		panic(fmt.Errorf("failed to write cquery file: %s", err))
+	}
+	cmd := bazelCommand(append([]string{
+		"cquery",
+		universe(),
+		"--output",
+		"starlark",
+		"--starlark:file",
+		cqueryPath,
+	}, analysisFlags(cfg)...)...)
+	stderr := new(strings.Builder)
+	stdout := new(strings.Builder)
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to query header-only targets\n\n%s", stderr)
+		return nil
+	}
+
+	base, language := []string{compiler("clang")}, "c++"
+	if len(labels) > 0 {
+		t := ccTargets[labels[0]]
+		base, language = []string{t.args[0]}, t.language
+		for _, arg := range t.args[1:] {
+			if strings.HasPrefix(arg, "-std=") || strings.HasPrefix(arg, "/std:") {
+				base = append(base, arg)
+			}
+		}
+	}
+
+	var headerOnly []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		var info headerOnlyInfo
+		if err := json.Unmarshal([]byte(line), &info); err != nil {
+			panic(fmt.Errorf("failed to parse header-only targets: %s", err))
+		}
+		label := mainRepoLabel(info.Label)
+		if _, ok := ccTargets[label]; ok || incompatible[label] || isIgnoredLabel(label) {
+			continue
+		}
+		var srcs []string
+		for _, h := range info.Headers {
+			if !isIgnored(h) {
+				srcs = append(srcs, h)
+			}
+		}
+		if len(srcs) == 0 {
+			continue
+		}
+		ccTargets[label] = &ccTarget{
+			srcs:       srcs,
+			args:       headerOnlyArgs(base, info),
+			language:   language,
+			label:      label,
+			actions:    map[string][]*compileAction{},
+			headerOnly: true,
+		}
+		headerOnly = append(headerOnly, label)
+	}
+	sort.Strings(headerOnly)
+	return headerOnly
+}
+
+// headerOnlyArgs returns the arguments of the headers of a header-only
+// target: base followed by the flags of info.
+func headerOnlyArgs(base []string, info headerOnlyInfo) []string {
+	args := append([]string{}, base...)
+	clangCL := isClangCL(args[0])
+	for _, d := range info.Defines {
+		args = append(args, "-D"+d)
+	}
+	for _, dir := range info.QuoteIncludes {
+		if clangCL {
+			args = append(args, "-I"+execPath(dir))
+		} else {
+			args = append(args, "-iquote", execPath(dir))
+		}
+	}
+	for _, dir := range info.Includes {
+		args = append(args, "-I"+execPath(dir))
+	}
+	for _, dir := range info.SystemIncludes {
+		if clangCL {
+			args = append(args, "/external:I"+execPath(dir))
+		} else {
+			args = append(args, "-isystem", execPath(dir))
+		}
+	}
+	for _, dir := range info.FrameworkIncludes {
+		args = append(args, "-F"+execPath(dir))
+	}
+	return args
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isExternalPath reports whether p, a path resolved by execPath, is in an
+// external repository, either its sources under the output base or its
+// generated files under bazel-out.
+func isExternalPath(p string) bool {
+	if strings.HasPrefix(p, outputBaseDir+"/external/") {
+		return true
+	}
+	return strings.HasPrefix(p, executionRoot+"/bazel-out/") && strings.Contains(p, "/bin/external/")
+}
+
+// externalIncludesAsSystem turns the -I flags of include directories in
+// external repositories into -isystem flags with --external-isystem, so that
+// tools don't report the warnings of third-party headers. clang-cl, which
+// has no -isystem, keeps its flags.
+func externalIncludesAsSystem(a *compileAction, args []string) []string {
+	if !*externalIsystem || isClangCL(args[0]) {
+		return args
+	}
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case i > 0 && arg == "-I" && i+1 < len(args) && isExternalPath(args[i+1]):
+			i++
+			out = append(out, "-isystem", args[i])
+		case strings.HasPrefix(arg, "-I") && len(arg) > 2 && isExternalPath(arg[2:]):
+			out = append(out, "-isystem", arg[2:])
+		default:
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// iquoteRoots returns the flags adding bazel-bin, the execution root and the
+// output base to the quoted include directories of an entry with args,
+// according to --iquote-roots. Actions have the include directories they
+// need, so these are only for layouts where those aren't enough.
+func iquoteRoots(args []string) []string {
+	switch *iquoteRootsFlag {
+	case "never":
+		return nil
+	case "missing":
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "-iquote") {
+				return nil
+			}
+		}
+	case "always":
+	default:
+		panic(fmt.Errorf("invalid --iquote-roots %q, expected never, missing or always", *iquoteRootsFlag))
+	}
+	// clang-cl has no -iquote
+	iquote := "-iquote"
+	if isClangCL(args[0]) {
+		iquote = "/I"
+	}
+	return []string{iquote, binDir, iquote, executionRoot, iquote, outputBaseDir}
+}
+
+// includeFlags are the flags adding include directories.
+var includeFlags = []string{"-I", "-iquote", "-isystem", "-idirafter"}
+
+// includeFlag returns the include flag args[i] is, if any, with its
+// directory, and whether the directory is the next argument.
+func includeFlag(args []string, i int) (flag, dir string, separate bool) {
+	for _, f := range includeFlags {
+		switch {
+		case args[i] == f && i+1 < len(args):
+			return f, args[i+1], true
+		case strings.HasPrefix(args[i], f) && len(args[i]) > len(f):
+			return f, args[i][len(f):], false
+		}
+	}
+	return "", "", false
+}
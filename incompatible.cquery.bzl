@@ -0,0 +1,9 @@
+# A formatting function for Bazel cquery results
+#
+# Formats the target as its label if it is incompatible with the target platform.
+
+def format(target):
+    for name in providers(target) or {}:
+        if name.endswith("IncompatiblePlatformProvider"):
+            return str(target.label)
+    return ""
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// instrumentationFlagPrefixes are the prefixes of the flags of sanitizers,
+// coverage, profiling and LTO.
+var instrumentationFlagPrefixes = []string{
+	"-fsanitize",
+	"-fno-sanitize",
+	"-fprofile-",
+	"-fno-profile-",
+	"-fcs-profile-",
+	"-fcoverage-",
+	"-ftest-coverage",
+	"--coverage",
+	"-flto",
+	"-fno-lto",
+	"-fwhole-program-vtables",
+	"-fsplit-lto-unit",
+}
+
+// gccInstrumentationRegexp matches the instrumentation flags of GCC that
+// clang doesn't understand.
+var gccInstrumentationRegexp = regexp.MustCompile(`^(-flto=(auto|jobserver|\d+)|-flto-partition=.*|-flto-compression-level=.*|-fprofile-partial-training|-fprofile-update=prefer-atomic)$`)
+
+// instrumentationFileFlags are the instrumentation flags naming a file
+// that must exist, like a profile or a sanitizer ignore list.
+var instrumentationFileFlags = []string{
+	"-fprofile-use=",
+	"-fprofile-instr-use=",
+	"-fprofile-sample-use=",
+	"-fprofile-list=",
+	"-fsanitize-blacklist=",
+	"-fsanitize-ignorelist=",
+}
+
+// applyInstrumentationPolicy handles the flags of sanitizers, coverage,
+// profiling and LTO according to --instrumentation: `keep` leaves them,
+// `strip` drops all of them, and `strip-unknown` only drops the ones the
+// consumer can't use, the GCC-only ones and those naming missing files.
+func applyInstrumentationPolicy(a *compileAction, args []string) []string {
+	switch *instrumentation {
+	case "keep":
+		return args
+	case "strip":
+		return filterArgs(args, func(arg string) bool {
+			return !hasAnyPrefix(arg, instrumentationFlagPrefixes)
+		})
+	case "strip-unknown":
+		return filterArgs(args, func(arg string) bool {
+			return !gccInstrumentationRegexp.MatchString(arg) && !missingInstrumentationFile(arg)
+		})
+	}
+	panic(fmt.Errorf("invalid --instrumentation %q, expected keep, strip or strip-unknown", *instrumentation))
+}
+
+// missingInstrumentationFile reports whether arg is one of
+// instrumentationFileFlags naming a file that doesn't exist.
+func missingInstrumentationFile(arg string) bool {
+	for _, f := range instrumentationFileFlags {
+		if !strings.HasPrefix(arg, f) {
+			continue
+		}
+		p := execPath(strings.TrimPrefix(arg, f))
+		if !isAbs(p) {
+			p = path.Join(workspace, p)
+		}
+		_, err := os.Stat(p)
+		return err != nil
+	}
+	return false
+}
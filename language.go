@@ -0,0 +1,127 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// sourceLanguages maps the extensions of sources to the language clang
+// infers for them, as named by -x.
+var sourceLanguages = map[string]string{
+	".c":    "c",
+	".cc":   "c++",
+	".cpp":  "c++",
+	".cxx":  "c++",
+	".c++":  "c++",
+	".C":    "c++",
+	".m":    "objective-c",
+	".mm":   "objective-c++",
+	".cppm": "c++-module",
+	".cxxm": "c++-module",
+	".c++m": "c++-module",
+	".ccm":  "c++-module",
+	".ixx":  "c++-module",
+	".S":    "assembler-with-cpp",
+	".s":    "assembler",
+}
+
+// languagePrecedence ranks the languages of the sources of a target for
+// parsing its headers, which may be included from any of them. Languages
+// that can parse the headers of others rank higher.
+var languagePrecedence = map[string]int{
+	"assembler":          1,
+	"assembler-with-cpp": 1,
+	"c":                  2,
+	"c++":                3,
+	"c++-module":         3,
+	"objective-c":        3,
+	"objective-c++":      4,
+}
+
+// languageFlag returns the language set by the last -x flag of args, or by
+// clang-cl's /TC and /TP, or "" if there is none.
+func languageFlag(args []string) string {
+	var lang string
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-x" && i+1 < len(args):
+			i++
+			lang = args[i]
+		case strings.HasPrefix(arg, "-x") && len(arg) > 2:
+			lang = strings.TrimPrefix(arg, "-x")
+		case arg == "/TC":
+			lang = "c"
+		case arg == "/TP":
+			lang = "c++"
+		}
+	}
+	return lang
+}
+
+// actionLanguage returns the language of the source of an action: the one
+// set by its flags, or else the one of its extension. Actions of generated
+// directories fall back to their mnemonic.
+func actionLanguage(mnemonic string, args []string, src string) string {
+	if lang := languageFlag(args); lang != "" {
+		return lang
+	}
+	if lang, ok := sourceLanguages[path.Ext(src)]; ok {
+		return lang
+	}
+	if mnemonic == "ObjcCompile" {
+		return "objective-c++"
+	}
+	return "c++"
+}
+
+// headerArgs returns the arguments of target t for src, a file without an
+// action of its own like a header. Unless its extension says so, clang
+// wouldn't parse it as the language of the target, which is set explicitly.
+func headerArgs(t *ccTarget, src string) []string {
+	lang := t.language
+	if lang == "c++-module" {
+		// headers of module interfaces are plain C++
+		lang = "c++"
+	}
+	if lang == "" || sourceLanguages[path.Ext(src)] == lang {
+		return t.args
+	}
+	if strings.HasPrefix(lang, "assembler") {
+		// headers of assembly are parsed as C by default, like the
+		// preprocessor treats them
+		return t.args
+	}
+	args := append([]string{}, t.args...)
+	if isClangCL(args[0]) {
+		switch lang {
+		case "c":
+			return append(args, "/TC")
+		case "c++":
+			return append(args, "/TP")
+		}
+		return t.args
+	}
+	return append(args, "-x", lang+"-header")
+}
+
+// objcLanguages are the languages of the sources of ObjcCompile actions.
+var objcLanguages = map[string]string{
+	".m":  "objective-c",
+	".mm": "objective-c++",
+}
+
+// setLanguage replaces the -x flags of args with one for lang, right after
+// the compiler.
+func setLanguage(args []string, lang string) []string {
+	out := []string{args[0], "-x" + lang}
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-x" && i+1 < len(args):
+			i++
+		case strings.HasPrefix(arg, "-x") && len(arg) > 2:
+		default:
+			out = append(out, arg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// linkMnemonics are the mnemonics of the link actions of C++ and
+// Objective-C rules.
+var linkMnemonics = []string{"CppLink", "ObjcLink"}
+
+// linkPathFlags are the linker flags that take a path, besides pathFlags.
+var linkPathFlags = []pathFlag{
+	{"-L", true},
+	{"-Wl,-force_load,", true},
+	{"-Wl,--version-script=", true},
+	{"-Wl,-exported_symbols_list,", true},
+}
+
+// generateLinks collects the link commands of the universe in cfg, with the
+// paths of the libraries they link resolved. Entries name the linked output
+// rather than a source file.
+func generateLinks(cfg bazelConfig) []compileCommand {
+	flags := append(append([]pathFlag{}, linkPathFlags...), pathFlags...)
+	var linkCommands []compileCommand
+	for _, n := range linkMnemonics {
+		g := aquery(cfg, fmt.Sprintf(`mnemonic("%s", %s)`, n, universe()))
+		for _, action := range g.Actions {
+			if action.Mnemonic != n {
+				continue
+			}
+			label, ok := g.label(action)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "skipping %s action %q of missing target (%d)\n", n, action.ActionKey, action.TargetID)
+				continue
+			}
+			if isIgnoredLabel(label) {
+				continue
+			}
+			if isExecConfiguration(g.configuration(action)) && !*includeExecConfiguration {
+				continue
+			}
+			arguments := stripSandboxPaths(stripLaunchers(g.expandParamFiles(action)))
+			args := append([]string{compiler(arguments[0])}, arguments[1:]...)
+			env := actionEnv(action)
+			args = substituteXcodePlaceholders(g.rewritePaths(args, flags), env)
+			var output string
+			if action.PrimaryOutputID != 0 {
+				output = execPath(g.artifactPath(action.PrimaryOutputID))
+			}
+			linkCommands = append(linkCommands, compileCommand{
+				Directory: entryDirectory(),
+				Arguments: args,
+				Output:    output,
+				env:       env,
+			})
+		}
+	}
+	return linkCommands
+}
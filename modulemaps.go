@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// moduleMapFlagPrefixes are the prefixes of the flags of Clang modules and
+// layering_check, which reference module maps Bazel generates.
+var moduleMapFlagPrefixes = []string{
+	"-fmodules",
+	"-fmodule-name=",
+	"-fmodule-map-file=",
+	"-fmodule-map-file-home-is-cwd",
+	"-fimplicit-module-maps",
+	"-fno-implicit-module-maps",
+	"-Wprivate-header",
+}
+
+// applyModuleMapPolicy handles the module map flags of args according to
+// --module-maps: `keep` leaves them, `strip` drops all of them and `resolve`
+// only drops the module maps that don't exist locally.
+func applyModuleMapPolicy(a *compileAction, args []string) []string {
+	switch *moduleMaps {
+	case "keep":
+		return args
+	case "strip", "resolve":
+	default:
+		panic(fmt.Errorf("invalid --module-maps %q, expected keep, strip or resolve", *moduleMaps))
+	}
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		// flags given to cc1 with -Xclang are kept or dropped with it
+		unit := args[i : i+1]
+		if args[i] == "-Xclang" && i+1 < len(args) {
+			unit = args[i : i+2]
+		}
+		f := unit[len(unit)-1]
+		if i > 0 && hasAnyPrefix(f, moduleMapFlagPrefixes) {
+			if *moduleMaps == "strip" || !moduleMapExists(f) {
+				i += len(unit) - 1
+				continue
+			}
+		}
+		out = append(out, unit...)
+		i += len(unit) - 1
+	}
+	return out
+}
+
+// moduleMapExists reports whether the module map of f exists locally, if
+// it's a -fmodule-map-file flag.
+func moduleMapExists(f string) bool {
+	m := strings.TrimPrefix(f, "-fmodule-map-file=")
+	if m == f {
+		return true
+	}
+	if !isAbs(m) {
+		m = path.Join(workspace, m)
+	}
+	_, err := os.Stat(m)
+	return err == nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import "strings"
+
+// msvcFlags maps cl.exe flags without a value to their clang equivalents, or
+// to nothing if clang has none and the flag is dropped.
+var msvcFlags = map[string][]string{
+	"/EHa":    {"-fexceptions", "-fcxx-exceptions"},
+	"/EHs":    {"-fexceptions", "-fcxx-exceptions"},
+	"/EHsc":   {"-fexceptions", "-fcxx-exceptions"},
+	"/GR":     {"-frtti"},
+	"/GR-":    {"-fno-rtti"},
+	"/TC":     {"-xc"},
+	"/TP":     {"-xc++"},
+	"/W0":     {"-w"},
+	"/W1":     {"-Wall"},
+	"/W2":     {"-Wall"},
+	"/W3":     {"-Wall"},
+	"/W4":     {"-Wall", "-Wextra"},
+	"/Wall":   {"-Wall", "-Wextra"},
+	"/WX":     {"-Werror"},
+	"/bigobj": nil,
+	"/nologo": nil,
+}
+
+// msvcValueFlags maps cl.exe flags taking a value, attached or in the next
+// argument, to the clang flag taking the same value. The value is dropped
+// along with flags mapped to "".
+var msvcValueFlags = []struct {
+	flag  string
+	clang string
+}{
+	{"/external:I", "-isystem"},
+	{"/I", "-I"},
+	{"/D", "-D"},
+	{"/U", "-U"},
+	{"/FI", "-include"},
+	{"/Fo", "-o"},
+	{"/Fd", ""},
+	{"/Fa", ""},
+	{"/Fp", ""},
+}
+
+// msvcStandards maps the values of cl.exe's /std: to clang's -std=.
+var msvcStandards = map[string]string{
+	"c++14":     "c++14",
+	"c++17":     "c++17",
+	"c++20":     "c++20",
+	"c++latest": "c++2b",
+	"c11":       "c11",
+	"c17":       "c17",
+}
+
+// isMSVC reports whether compiler is cl.exe.
+func isMSVC(compiler string) bool {
+	return compilerName(compiler) == "cl"
+}
+
+// translateMSVCArgs translates the arguments of a cl.exe action into the
+// ones of the gnu-style clang driver, which editors can use, so that they go
+// through the rest of the pipeline like the arguments of any other action.
+// Flags without a clang equivalent, like optimizations and debug info
+// options, are dropped.
+func translateMSVCArgs(args []string) []string {
+	out := []string{args[0], "--driver-mode=g++"}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "/") && !strings.HasPrefix(arg, "-") {
+			out = append(out, arg)
+			continue
+		}
+		flag := "/" + arg[1:]
+		if clang, ok := msvcFlags[flag]; ok {
+			out = append(out, clang...)
+			continue
+		}
+		if flag == "/c" {
+			out = append(out, "-c")
+			continue
+		}
+		if strings.HasPrefix(flag, "/std:") {
+			if std, ok := msvcStandards[strings.TrimPrefix(flag, "/std:")]; ok {
+				out = append(out, "-std="+std)
+			}
+			continue
+		}
+		matched := false
+		for _, f := range msvcValueFlags {
+			if !strings.HasPrefix(flag, f.flag) {
+				continue
+			}
+			value := strings.TrimPrefix(flag, f.flag)
+			if value == "" && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if f.clang != "" {
+				out = append(out, f.clang, value)
+			}
+			matched = true
+			break
+		}
+		if !matched && strings.HasPrefix(arg, "-") {
+			// cl.exe accepts both prefixes, but gnu-style flags from
+			// copts are kept as they are.
+			out = append(out, arg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// msysDriveRegexp matches the drive of an MSYS2 or Cygwin path, as in /c/...
+// or /cygdrive/c/..., capturing the drive letter.
+var msysDriveRegexp = regexp.MustCompile(`^/(?:cygdrive/)?([a-zA-Z])(?:/|$)`)
+
+// the Windows directory of the MSYS2 root, once looked up
+var msysRoot *string
+
+// msysPath rewrites p to a native Windows path if it's a path in the MSYS2
+// namespace, which mingw-w64 toolchains put in their flags and which editors
+// don't understand. Drive paths like /c/... are rewritten directly, others,
+// like /mingw64/include, are resolved under the MSYS2 root, if cygpath is
+// found to tell where it is.
+func msysPath(p string) string {
+	if runtime.GOOS != "windows" || !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") {
+		return p
+	}
+	if m := msysDriveRegexp.FindStringSubmatch(p); m != nil {
+		return strings.ToUpper(m[1]) + ":/" + p[len(m[0]):]
+	}
+	if root := getMSYSRoot(); root != "" {
+		return path.Join(root, p)
+	}
+	return p
+}
+
+// getMSYSRoot returns the Windows directory of the MSYS2 root, or "" if
+// cygpath isn't available.
+func getMSYSRoot() string {
+	if msysRoot != nil {
+		return *msysRoot
+	}
+	root := ""
+	if out, err := exec.Command("cygpath", "-m", "/").Output(); err == nil {
+		root = strings.TrimSuffix(toSlash(strings.TrimSpace(string(out))), "/")
+	}
+	msysRoot = &root
+	return root
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// expandParamFiles returns the arguments of a with every @file argument
+// naming a param file replaced by the arguments in it. The contents come from
+// the aquery output when it has them (--include_param_files) and otherwise
+// from the file in the execution root, which exists after a build. Param
+// files that can't be read are left as they are.
+func (g *actionGraph) expandParamFiles(a action) []string {
+	params := map[string][]string{}
+	for _, p := range a.ParamFiles {
+		params[p.ExecPath] = p.Arguments
+	}
+	args := make([]string, 0, len(a.Arguments))
+	for i, arg := range a.Arguments {
+		if i == 0 || !strings.HasPrefix(arg, "@") {
+			args = append(args, arg)
+			continue
+		}
+		name := strings.TrimPrefix(arg, "@")
+		if contents, ok := params[name]; ok {
+			args = append(args, contents...)
+			continue
+		}
+		if contents, ok := readParamFile(name); ok {
+			args = append(args, contents...)
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// readParamFile reads the param file at the exec path name, which holds one
+// argument per line.
+func readParamFile(name string) ([]string, bool) {
+	f, err := os.Open(path.Join(executionRoot, name))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var args []string
+	scn := bufio.NewScanner(f)
+	scn.Buffer(nil, 1<<20)
+	for scn.Scan() {
+		args = append(args, unquoteParam(scn.Text()))
+	}
+	if scn.Err() != nil {
+		return nil, false
+	}
+	return args, true
+}
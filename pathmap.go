@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pathMapping replaces the root from of emitted paths by the root to.
+type pathMapping struct {
+	from, to string
+}
+
+// parsePathMappings parses the from=to rules of --path-map, longest roots
+// first so that nested roots win over their parents.
+func parsePathMappings(rules []string) []pathMapping {
+	var mappings []pathMapping
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			panic(fmt.Errorf("invalid --path-map %q, expected <from>=<to>", rule))
+		}
+		from := strings.TrimSuffix(toSlash(parts[0]), "/")
+		to := strings.TrimSuffix(toSlash(parts[1]), "/")
+		mappings = append(mappings, pathMapping{from, to})
+	}
+	sort.SliceStable(mappings, func(i, j int) bool {
+		return len(mappings[i].from) > len(mappings[j].from)
+	})
+	return mappings
+}
+
+// mapEntryPaths makes the paths of the entries relative with
+// --relative-paths or --directory=execroot and applies the mappings of
+// --path-map, --path-map-file, --portable, --convenience-symlinks and
+// --symlink-farm, so that a database generated where Bazel runs, like in a
+// container or on a remote build host, is valid where the editor runs. The
+// entries are of ws, in the databases of the root workspace.
+func mapEntryPaths(compileCommands []compileCommand, ws, root *workspaceState) []compileCommand {
+	if *relativePaths || *directoryFlag == "execroot" {
+		compileCommands = relativeEntryPaths(compileCommands)
+	}
+	rules := append(append(convenienceMappings(ws), portableMappings(ws, root)...), pathMaps...)
+	rules = append(append([]string{}, symlinkFarmRules...), rules...)
+	if *pathMapFile != "" {
+		rules = append(readPathMapFile(*pathMapFile), rules...)
+	}
+	mappings := parsePathMappings(rules)
+	if len(mappings) == 0 {
+		return compileCommands
+	}
+	return rewriteEntryPaths(compileCommands, func(s string) string {
+		return mapPaths(s, mappings)
+	})
+}
+
+// relativeEntryPaths returns the entries with the paths under their
+// directory relative to it, which stay valid wherever the directory is
+// mounted. The directory itself has to stay absolute.
+func relativeEntryPaths(compileCommands []compileCommand) []compileCommand {
+	out := make([]compileCommand, len(compileCommands))
+	for i, c := range compileCommands {
+		mappings := []pathMapping{{strings.TrimSuffix(c.Directory, "/"), "."}}
+		dir := c.Directory
+		c = rewriteEntryPaths([]compileCommand{c}, func(s string) string {
+			return mapPaths(s, mappings)
+		})[0]
+		c.Directory = dir
+		c.File = strings.TrimPrefix(c.File, "./")
+		c.Output = strings.TrimPrefix(c.Output, "./")
+		out[i] = c
+	}
+	return out
+}
+
+// readPathMapFile reads the from=to rules of a --path-map-file, one per
+// line, relative to the workspace. Lines starting with # are comments.
+func readPathMapFile(name string) []string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(workspace, name)
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		panic(fmt.Errorf("failed to read path map file: %s", err))
+	}
+	var rules []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			rules = append(rules, line)
+		}
+	}
+	return rules
+}
+
+// rewriteEntryPaths returns the entries with f applied to their directory,
+// file, output and arguments.
+func rewriteEntryPaths(compileCommands []compileCommand, f func(string) string) []compileCommand {
+	out := make([]compileCommand, len(compileCommands))
+	for i, c := range compileCommands {
+		c.Directory = f(c.Directory)
+		c.File = f(c.File)
+		c.Output = f(c.Output)
+		c.Command = f(c.Command)
+		args := make([]string, len(c.Arguments))
+		for j, arg := range c.Arguments {
+			args[j] = f(arg)
+		}
+		c.Arguments = args
+		out[i] = c
+	}
+	return out
+}
+
+// mapPaths replaces the roots of the paths in s, a path or an argument
+// holding paths like -I/root/include or --sysroot=/root, by their mappings.
+// Roots only match whole path components at the start of a path.
+func mapPaths(s string, mappings []pathMapping) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		mapped := false
+		if startsPath(s, i) {
+			for _, m := range mappings {
+				end := i + len(m.from)
+				if strings.HasPrefix(s[i:], m.from) && (end == len(s) || s[end] == '/') {
+					b.WriteString(m.to)
+					i = end
+					mapped = true
+					break
+				}
+			}
+		}
+		if !mapped {
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// startsPath reports whether a path may start at index i of s: at its
+// start, after a separator like = or after a flag like -I.
+func startsPath(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	switch s[i-1] {
+	case '=', ',', ':', ';', ' ', '"', '\'':
+		return true
+	}
+	return strings.HasPrefix(s, "-") && !strings.ContainsAny(s[:i], "/=")
+}
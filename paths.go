@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Paths are handled with forward slashes on every platform, like Bazel
+// prints them, and Windows paths are normalized to that form with toSlash
+// and hostPath as they come in. Windows accepts forward slashes everywhere,
+// so the paths can be passed to the os package and to tools as they are.
+
+// toSlash normalizes the separators of p, and the drive letter of an
+// absolute Windows path, which is uppercased. It returns p unchanged on
+// other platforms, where backslashes are valid in file names.
+func toSlash(p string) string {
+	if runtime.GOOS != "windows" {
+		return p
+	}
+	p = strings.ReplaceAll(p, `\`, "/")
+	if hasDriveLetter(p) {
+		p = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return p
+}
+
+// hostPath normalizes p, an existing path of the host like the ones of
+// bazel info. On Windows these may use 8.3 short names, like PROGRA~1, which
+// filepath.EvalSymlinks expands.
+func hostPath(p string) string {
+	if runtime.GOOS == "windows" {
+		if long, err := filepath.EvalSymlinks(p); err == nil {
+			p = long
+		}
+	}
+	return toSlash(p)
+}
+
+// hasDriveLetter reports whether p starts with a drive letter, as in C:/.
+func hasDriveLetter(p string) bool {
+	if len(p) < 3 || p[1] != ':' || p[2] != '/' && p[2] != '\\' {
+		return false
+	}
+	c := p[0] | 0x20
+	return c >= 'a' && c <= 'z'
+}
+
+// isAbs reports whether p is absolute, including Windows paths with a
+// drive letter.
+func isAbs(p string) bool {
+	return path.IsAbs(p) || hasDriveLetter(p)
+}
+
+// isLink reports whether m is the mode of a symlink, or on Windows of a
+// junction, which Bazel creates for local repositories and the convenience
+// symlinks and which recent Go versions report as irregular files.
+func isLink(m os.FileMode) bool {
+	return m&os.ModeSymlink != 0 || runtime.GOOS == "windows" && m&os.ModeIrregular != 0
+}
+
+// readLink returns the target of the symlink or junction p, without the
+// \\?\ prefix of the targets of Windows junctions.
+func readLink(p string) (string, error) {
+	target, err := os.Readlink(p)
+	return strings.TrimPrefix(target, `\\?\`), err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestJoinCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are quoted following the Windows rules")
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"clang", "-I/src/my project/include", "-c", "/src/my project/a.cc"},
+			"clang '-I/src/my project/include' -c '/src/my project/a.cc'",
+		},
+		{
+			[]string{"clang", "-I/src/工程/include", "/src/工程/主.cc"},
+			"clang '-I/src/工程/include' '/src/工程/主.cc'",
+		},
+		{
+			[]string{"clang", "-I/src/lib (copy)", "/src/lib (copy)/a.c"},
+			"clang '-I/src/lib (copy)' '/src/lib (copy)/a.c'",
+		},
+	}
+	for _, tt := range tests {
+		got := joinCommand(tt.args)
+		if got != tt.want {
+			t.Errorf("joinCommand(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+		if split := splitCommand(got); len(split) != len(tt.args) {
+			t.Errorf("splitCommand(%q) = %q, want %q", got, split, tt.args)
+		}
+	}
+}
+
+func TestMapPaths(t *testing.T) {
+	mappings := parsePathMappings([]string{
+		"/home/me/my project=/work/my project",
+		"/home/me/工程=/work/工程",
+		"/home/me/lib (copy)=/work/lib",
+	})
+	tests := []struct {
+		s, want string
+	}{
+		{"/home/me/my project/a.cc", "/work/my project/a.cc"},
+		{"-I/home/me/my project/include", "-I/work/my project/include"},
+		{"--sysroot=/home/me/工程/sysroot", "--sysroot=/work/工程/sysroot"},
+		{"/home/me/工程/主.cc", "/work/工程/主.cc"},
+		{"/home/me/lib (copy)/a.h", "/work/lib/a.h"},
+		{"/home/me/my project2/a.cc", "/home/me/my project2/a.cc"},
+		{"/home/me/lib (copy)", "/work/lib"},
+	}
+	for _, tt := range tests {
+		if got := mapPaths(tt.s, mappings); got != tt.want {
+			t.Errorf("mapPaths(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestExecPath(t *testing.T) {
+	base := toSlash(filepath.Join(t.TempDir(), "output base (工程)"))
+	if err := os.MkdirAll(filepath.Join(base, "external", "my repo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(e, o string) { executionRoot, outputBaseDir = e, o }(executionRoot, outputBaseDir)
+	outputBaseDir = base
+	executionRoot = path.Join(base, "execroot", "_main")
+
+	tests := []struct {
+		p, want string
+	}{
+		{"my dir/a (1).cc", "my dir/a (1).cc"},
+		{"工程/主.cc", "工程/主.cc"},
+		{"bazel-out/k8-fastbuild/bin/my dir/a.pb.h", path.Join(executionRoot, "bazel-out/k8-fastbuild/bin/my dir/a.pb.h")},
+		{"external/my repo/include (v2)/a.h", path.Join(base, "external/my repo/include (v2)/a.h")},
+		{path.Join(base, "工程 (copy)/a.h"), path.Join(base, "工程 (copy)/a.h")},
+	}
+	for _, tt := range tests {
+		if got := execPath(tt.p); got != tt.want {
+			t.Errorf("execPath(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pchExtensions are the extensions precompiled headers add to the name of
+// their header, as in foo.h.pch.
+var pchExtensions = []string{".pch", ".gch"}
+
+// binPathRegexp matches a resolved path in bazel-bin, capturing its exec
+// path relative to bazel-bin.
+var binPathRegexp = regexp.MustCompile(`/bazel-out/[^/]+/bin/(.*)$`)
+
+// substitutePCH replaces the precompiled headers of args with the headers
+// they were compiled from, with --pch substitute. Precompiled headers are
+// outputs that usually don't exist for the editor, and clangd builds its own
+// preamble anyway. Flags only meaningful while building one are dropped.
+func substitutePCH(a *compileAction, args []string) []string {
+	switch *pch {
+	case "keep":
+		return args
+	case "substitute":
+	default:
+		panic(fmt.Errorf("invalid --pch %q, expected substitute or keep", *pch))
+	}
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case i == 0:
+		case arg == "-include-pch" && i+1 < len(args):
+			i++
+			if h := pchHeader(args[i]); h != "" {
+				out = append(out, "-include", h)
+			}
+			continue
+		case arg == "-Xclang" && i+3 < len(args) && args[i+1] == "-include-pch" && args[i+2] == "-Xclang":
+			i += 3
+			if h := pchHeader(args[i]); h != "" {
+				out = append(out, "-include", h)
+			}
+			continue
+		case strings.HasPrefix(arg, "-fpch-"):
+			continue
+		case strings.HasPrefix(arg, "/Yu") && isClangCL(args[0]):
+			// the header is named as it's included
+			if h := strings.TrimPrefix(arg, "/Yu"); h != "" {
+				out = append(out, "/FI"+h)
+			}
+			continue
+		case (strings.HasPrefix(arg, "/Yc") || strings.HasPrefix(arg, "/Fp")) && isClangCL(args[0]):
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// pchHeader returns the header the precompiled header p, a resolved path,
+// was compiled from, in the workspace if it's a source, or "" if its name
+// doesn't tell.
+func pchHeader(p string) string {
+	h := p
+	for _, ext := range pchExtensions {
+		h = strings.TrimSuffix(h, ext)
+	}
+	if h == p || path.Ext(h) == "" {
+		return ""
+	}
+	if m := binPathRegexp.FindStringSubmatch(h); m != nil {
+		if _, err := os.Stat(path.Join(workspace, m[1])); err == nil {
+			return execPath(m[1])
+		}
+	}
+	return h
+}
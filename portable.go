@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The placeholders of the roots of portable databases.
+const (
+	execRootPlaceholder   = "${EXEC_ROOT}"
+	outputBasePlaceholder = "${OUTPUT_BASE}"
+	workspacePlaceholder  = "${WORKSPACE}"
+)
+
+// portableMappings returns the rules replacing the roots of the machine by
+// their placeholders with --portable, for the entries of ws in the databases
+// of the root workspace. The execution root and output base are the ones of
+// ws, and the workspace the root one, of which the workspaces of
+// --workspaces are usually subdirectories.
+func portableMappings(ws, root *workspaceState) []string {
+	if !*portable {
+		return nil
+	}
+	return []string{
+		ws.executionRoot + "=" + execRootPlaceholder,
+		ws.outputBaseDir + "=" + outputBasePlaceholder,
+		root.dir + "=" + workspacePlaceholder,
+	}
+}
+
+// instantiate replaces the placeholders of the portable database in by the
+// roots of this machine, and writes the result to out in the workspace,
+// compile_commands.json by default. It is run once per checkout, like
+// `generate_compile_commands instantiate compile_commands.portable.json`.
+func instantiate(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		panic(fmt.Errorf("usage: instantiate <portable database> [<output>]"))
+	}
+	in, out := args[0], "compile_commands.json"
+	if len(args) == 2 {
+		out = args[1]
+	}
+	if !filepath.IsAbs(in) {
+		in = path.Join(workspace, in)
+	}
+	content, err := ioutil.ReadFile(in)
+	if err != nil {
+		panic(fmt.Errorf("failed to read portable database: %s", err))
+	}
+	var compileCommands []compileCommand
+	if err := json.Unmarshal(content, &compileCommands); err != nil {
+		panic(fmt.Errorf("failed to parse portable database %s: %s", in, err))
+	}
+	executionRoot = getBazelInfo("execution_root")
+	outputBaseDir = getBazelInfo("output_base")
+	replacer := strings.NewReplacer(
+		execRootPlaceholder, executionRoot,
+		outputBasePlaceholder, outputBaseDir,
+		workspacePlaceholder, workspace,
+	)
+	writeJSON(out, rewriteEntryPaths(compileCommands, replacer.Replace))
+}
@@ -0,0 +1,102 @@
+# A repository rule that fetches a prebuilt generate_compile_commands binary
+#
+# Workspaces that don't register a Go toolchain can use this instead of
+# building the generator from source:
+#
+#   load("@bazel_compile_commands//:prebuilt.bzl", "generate_compile_commands_prebuilt")
+#
+#   generate_compile_commands_prebuilt(
+#       name = "bazel_compile_commands_bin",
+#       version = "v0.1.0",
+#       sha256s = {...},  # printed by tools/release.sh
+#   )
+#
+# and then `bazel run @bazel_compile_commands_bin//:generate_compile_commands`.
+
+_DEFAULT_URL = "https://github.com/chriscraws/bazel-compile-commands/releases/download/{version}/generate_compile_commands_{platform}{ext}"
+
+def _host_platform(rctx):
+    os = rctx.os.name.lower()
+    if os.startswith("mac os"):
+        os = "darwin"
+    elif os.startswith("linux"):
+        os = "linux"
+    elif os.startswith("windows"):
+        os = "windows"
+    else:
+        fail("unsupported host os %r" % rctx.os.name)
+
+    arch = rctx.os.arch
+    if arch in ["x86_64", "amd64"]:
+        arch = "amd64"
+    elif arch in ["aarch64", "arm64"]:
+        arch = "arm64"
+    else:
+        fail("unsupported host architecture %r" % arch)
+
+    return os, arch
+
+def _generate_compile_commands_prebuilt_impl(rctx):
+    os, arch = _host_platform(rctx)
+    platform = "%s_%s" % (os, arch)
+    ext = ".exe" if os == "windows" else ""
+
+    url = rctx.attr.url.format(
+        version = rctx.attr.version,
+        platform = platform,
+        ext = ext,
+    )
+    if platform not in rctx.attr.sha256s:
+        fail("no sha256 for %s in sha256s of %s" % (platform, rctx.attr.name))
+    binary = "generate_compile_commands_%s%s" % (platform, ext)
+    rctx.download(
+        url = url,
+        output = binary,
+        sha256 = rctx.attr.sha256s[platform],
+        executable = True,
+    )
+
+    # the binary is run as it is, rather than through a shell script that
+    # would need bash on Windows
+    rctx.file("defs.bzl", """\
+# Generated by generate_compile_commands_prebuilt
+
+def _prebuilt_binary_impl(ctx):
+    out = ctx.actions.declare_file(ctx.label.name + "{ext}")
+    ctx.actions.symlink(output = out, target_file = ctx.file.src, is_executable = True)
+    return [DefaultInfo(executable = out, files = depset([out]))]
+
+prebuilt_binary = rule(
+    implementation = _prebuilt_binary_impl,
+    attrs = {{"src": attr.label(allow_single_file = True, mandatory = True)}},
+    executable = True,
+)
+""".format(ext = ext))
+    rctx.file("BUILD.bazel", """\
+# Generated by generate_compile_commands_prebuilt
+
+load(":defs.bzl", "prebuilt_binary")
+
+prebuilt_binary(
+    name = "generate_compile_commands",
+    src = "{binary}",
+    visibility = ["//visibility:public"],
+)
+""".format(binary = binary))
+
+generate_compile_commands_prebuilt = repository_rule(
+    implementation = _generate_compile_commands_prebuilt_impl,
+    attrs = {
+        "version": attr.string(
+            mandatory = True,
+            doc = "The release tag to fetch binaries from.",
+        ),
+        "sha256s": attr.string_dict(
+            doc = "Expected sha256 of the binary, keyed by <os>_<arch>. Fetching fails on platforms without one.",
+        ),
+        "url": attr.string(
+            default = _DEFAULT_URL,
+            doc = "URL template with {version}, {platform} and {ext} placeholders.",
+        ),
+    },
+)
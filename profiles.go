@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// translationProfile translates the arguments of the compilers of a cross
+// toolchain family, which clang based consumers don't know, into the ones
+// of clang for the same target.
+type translationProfile struct {
+	name string
+	// matches the names of the compilers, as returned by compilerName
+	compilers *regexp.Regexp
+	// clang target triple added with --target=
+	target string
+	// flags dropped, or prefixes of flags when they end with =
+	drop []string
+	// replacements of flags, or of the prefixes of flags ending with =
+	// which keep their value, and "" to drop them
+	rename map[string]string
+	// flags taking a value in the next argument, mapped to the flag the
+	// value is attached to, or to "" to drop both
+	valueFlags map[string]string
+	// add the --sysroot= printed by the compiler's -print-sysroot
+	sysroot bool
+	// sysroot added with --sysroot=, instead of the compiler's
+	sysrootPath string
+	// directories added with -isystem
+	includes []string
+	// add the builtin include directories of the compiler, as selected by
+	// the -m flags, like the multilib directories of newlib
+	builtinIncludes bool
+}
+
+// translationProfiles are the built-in translation profiles.
+var translationProfiles = []translationProfile{
+	{
+		name:            "arm-none-eabi",
+		compilers:       regexp.MustCompile(`^arm-none-eabi-(gcc|g\+\+|cc|c\+\+)(-[\d.]+)?$`),
+		target:          "arm-none-eabi",
+		drop:            []string{"-specs=", "--specs="},
+		sysroot:         true,
+		builtinIncludes: true,
+	},
+}
+
+// profileOf returns the translation profile of compiler, or nil.
+func profileOf(compiler string) *translationProfile {
+	name := compilerName(compiler)
+	for i := range translationProfiles {
+		if translationProfiles[i].compilers.MatchString(name) {
+			return &translationProfiles[i]
+		}
+	}
+	return nil
+}
+
+// applyTranslationProfile translates the arguments of the actions whose
+// compiler has a translation profile, when the consumer is clang based.
+// Flags like -mcpu= are kept, as clang understands them for the target.
+func applyTranslationProfile(a *compileAction, args []string) []string {
+	if *consumer != "clang" || a.compiler == "" {
+		return args
+	}
+	p := profileOf(a.compiler)
+	if p == nil {
+		return args
+	}
+	var includes []string
+	if p.builtinIncludes {
+		includes = compilerBuiltinIncludes(a, args, func(arg string) bool {
+			return strings.HasPrefix(arg, "-m")
+		})
+	}
+	out := []string{args[0]}
+	if p.target != "" && !hasToolchainFlag(args, "--target=", "-target") {
+		out = append(out, "--target="+p.target)
+	}
+	if !hasToolchainFlag(args, "--sysroot=", "--sysroot") {
+		sysroot := execPath(p.sysrootPath)
+		if sysroot == "" && p.sysroot {
+			sysroot = gccSysroot(a.compiler)
+		}
+		if sysroot != "" {
+			out = append(out, "--sysroot="+sysroot)
+		}
+	}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if flag, ok := p.valueFlags[arg]; ok && i+1 < len(args) {
+			i++
+			if flag != "" {
+				out = append(out, flag+args[i])
+			}
+			continue
+		}
+		if matchesFlag(arg, p.drop) {
+			continue
+		}
+		out = append(out, renameFlag(arg, p.rename)...)
+	}
+	for _, dir := range p.includes {
+		out = append(out, "-isystem", execPath(dir))
+	}
+	return append(out, includes...)
+}
+
+// renameFlag returns arg renamed by the replacements of rename, or arg.
+func renameFlag(arg string, rename map[string]string) []string {
+	if r, ok := rename[arg]; ok {
+		if r == "" {
+			return nil
+		}
+		return []string{r}
+	}
+	// the longest prefix wins
+	prefix := ""
+	for f := range rename {
+		if strings.HasSuffix(f, "=") && strings.HasPrefix(arg, f) && len(f) > len(prefix) {
+			prefix = f
+		}
+	}
+	if prefix == "" {
+		return []string{arg}
+	}
+	if r := rename[prefix]; r != "" {
+		return []string{r + strings.TrimPrefix(arg, prefix)}
+	}
+	return nil
+}
+
+// matchesFlag reports whether arg is one of flags, or starts with one of
+// them that ends with =.
+func matchesFlag(arg string, flags []string) bool {
+	for _, f := range flags {
+		if arg == f || strings.HasSuffix(f, "=") && strings.HasPrefix(arg, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasToolchainFlag reports whether args set a flag with the attached or the
+// separate form.
+func hasToolchainFlag(args []string, attached, separate string) bool {
+	for _, arg := range args[1:] {
+		if arg == separate || strings.HasPrefix(arg, attached) {
+			return true
+		}
+	}
+	return false
+}
+
+// sysroots of GCC compilers, once probed
+var gccSysroots = map[string]string{}
+
+// gccSysroot returns the sysroot GCC was configured with, like the
+// arm-none-eabi directory of the Arm GNU Toolchain holding newlib, or "".
+func gccSysroot(compiler string) string {
+	if s, ok := gccSysroots[compiler]; ok {
+		return s
+	}
+	cmd := exec.Command(compiler, "-print-sysroot")
+	cmd.Dir = executionRoot
+	out, err := cmd.Output()
+	s := ""
+	if err == nil {
+		s = strings.TrimSpace(string(out))
+		if s != "" {
+			s = execPath(path.Clean(toSlash(s)))
+		}
+	}
+	gccSysroots[compiler] = s
+	return s
+}
+
+// profileConfig is a translation profile of a --profiles file.
+type profileConfig struct {
+	Name            string
+	Compilers       string
+	Target          string
+	Drop            []string
+	Rename          map[string]string
+	ValueFlags      map[string]string `json:"valueFlags"`
+	Sysroot         string
+	Includes        []string
+	BuiltinIncludes bool `json:"builtinIncludes"`
+}
+
+// loadTranslationProfiles reads the translation profiles of the JSON file
+// p, relative to the workspace, a list of profileConfig objects, which take
+// precedence over the built-in profiles. A sysroot of "gcc" is the one the
+// compiler prints, and relative paths in the profiles are relative to the
+// execution root, like the ones of actions.
+func loadTranslationProfiles(p string) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(workspace, p)
+	}
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		panic(fmt.Errorf("failed to read translation profiles: %s", err))
+	}
+	var configs []profileConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		panic(fmt.Errorf("failed to parse translation profiles of %s: %s", p, err))
+	}
+	var profiles []translationProfile
+	for _, c := range configs {
+		compilers, err := regexp.Compile(c.Compilers)
+		if err != nil {
+			panic(fmt.Errorf("invalid compilers of translation profile %q: %s", c.Name, err))
+		}
+		profile := translationProfile{
+			name:            c.Name,
+			compilers:       compilers,
+			target:          c.Target,
+			drop:            c.Drop,
+			rename:          c.Rename,
+			valueFlags:      c.ValueFlags,
+			includes:        c.Includes,
+			builtinIncludes: c.BuiltinIncludes,
+		}
+		if c.Sysroot == "gcc" {
+			profile.sysroot = true
+		} else {
+			profile.sysrootPath = c.Sysroot
+		}
+		profiles = append(profiles, profile)
+	}
+	translationProfiles = append(profiles, translationProfiles...)
+}
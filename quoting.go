@@ -0,0 +1,148 @@
+package main
+
+import (
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Arguments are kept as the exact strings the compiler receives, from the
+// aquery output to the arguments arrays of the database. Quoting only
+// happens at the edges: when reading param files, which Bazel writes quoted,
+// and when joining arguments into command strings.
+
+// joinCommand joins args into a command line, quoted the way clang's
+// compilation database splits it again: with Windows rules on Windows and
+// POSIX shell rules elsewhere.
+func joinCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if runtime.GOOS == "windows" {
+			quoted[i] = quoteWindows(arg)
+		} else {
+			quoted[i] = quotePOSIX(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// unquotedRegexp matches the arguments that don't need quoting in a POSIX
+// shell.
+var unquotedRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// quotePOSIX quotes arg for a POSIX shell, in single quotes unless it's made
+// of characters without special meaning only.
+func quotePOSIX(arg string) string {
+	if unquotedRegexp.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// quoteWindows quotes arg following the rules of CommandLineToArgvW, which
+// only give backslashes a special meaning in front of double quotes.
+func quoteWindows(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, c := range arg {
+		switch c {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(c)
+	}
+	b.WriteString(strings.Repeat(`\`, 2*backslashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquoteParam returns the argument of a line of a param file. Bazel writes
+// one argument per line, quoted according to the param file type of the
+// action: shell quoted, where arguments are wrapped in single quotes and
+// embedded single quotes are closed, escaped and reopened, or GCC quoted,
+// where special characters are escaped with backslashes. Unquoted lines are
+// returned as they are.
+func unquoteParam(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") {
+		return strings.ReplaceAll(s[1:len(s)-1], `'\''`, "'")
+	}
+	// backslashes are path separators in unquoted param files on Windows
+	if runtime.GOOS == "windows" || !gccEscapeRegexp.MatchString(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// gccEscapeRegexp matches the escapes of GCC quoted param files.
+var gccEscapeRegexp = regexp.MustCompile(`\\[\s"'\\]`)
+
+// splitCommand splits a command line printed by a tool, like the flags of
+// emcc --cflags, into its arguments following POSIX shell rules, so that
+// quoted paths with spaces stay whole.
+func splitCommand(s string) []string {
+	var args []string
+	var b strings.Builder
+	inArg := false
+	var quote rune
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				b.WriteRune(c)
+			}
+		case c == '\\' && i+1 < len(r):
+			// in double quotes, backslashes only escape the characters
+			// that are special there
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r[i+1]) {
+				b.WriteRune(c)
+				continue
+			}
+			i++
+			b.WriteRune(r[i])
+			inArg = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				b.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args
+}
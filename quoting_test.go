@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestQuotePOSIX(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{"-DFOO=1", "-DFOO=1"},
+		{"", "''"},
+		{"/path/with space/a.h", "'/path/with space/a.h'"},
+		{`-DMSG="hi"`, `'-DMSG="hi"'`},
+		{"it's", `'it'\''s'`},
+		{`C:\dir\a.c`, `'C:\dir\a.c'`},
+		{"$HOME", "'$HOME'"},
+	}
+	for _, tt := range tests {
+		if got := quotePOSIX(tt.arg); got != tt.want {
+			t.Errorf("quotePOSIX(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteWindows(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{`C:\dir\a.c`, `C:\dir\a.c`},
+		{"", `""`},
+		{`C:\Program Files\a.h`, `"C:\Program Files\a.h"`},
+		{`C:\with space\`, `"C:\with space\\"`},
+		{`-DMSG="hi"`, `"-DMSG=\"hi\""`},
+		{`a\"b`, `"a\\\"b"`},
+		{`a\\b c`, `"a\\b c"`},
+	}
+	for _, tt := range tests {
+		if got := quoteWindows(tt.arg); got != tt.want {
+			t.Errorf("quoteWindows(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestUnquoteParam(t *testing.T) {
+	tests := []struct {
+		line, want string
+		posixOnly  bool
+	}{
+		{"-DFOO=1", "-DFOO=1", false},
+		{"'/path/with space/a.h'", "/path/with space/a.h", false},
+		{`'it'\''s'`, "it's", false},
+		{"''", "", false},
+		{`/path/with\ space/a.h`, "/path/with space/a.h", true},
+		{`-DMSG=\"hi\"`, `-DMSG="hi"`, true},
+		{`a\\b`, `a\b`, true},
+		{`C:\dir\a.c`, `C:\dir\a.c`, false},
+	}
+	for _, tt := range tests {
+		if tt.posixOnly && runtime.GOOS == "windows" {
+			continue
+		}
+		if got := unquoteParam(tt.line); got != tt.want {
+			t.Errorf("unquoteParam(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"clang -c a.c", []string{"clang", "-c", "a.c"}},
+		{"  -I a\t-I\nb  ", []string{"-I", "a", "-I", "b"}},
+		{`-I"/with space" -I'/it''s'`, []string{"-I/with space", "-I/its"}},
+		{`-I/with\ space`, []string{"-I/with space"}},
+		{`"a\"b" "a\b" 'a\b'`, []string{`a"b`, `a\b`, `a\b`}},
+		{`"" ''`, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		if got := splitCommand(tt.command); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandRoundTrip(t *testing.T) {
+	args := []string{
+		"clang",
+		"-DMSG=\"hello world\"",
+		"-I/path/with space",
+		"it's",
+		`back\slash`,
+		`trailing\`,
+		"tab\there",
+		"",
+		"$HOME",
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quotePOSIX(arg)
+	}
+	command := strings.Join(quoted, " ")
+	if got := splitCommand(command); !reflect.DeepEqual(got, args) {
+		t.Errorf("splitCommand(%q) = %q, want %q", command, got, args)
+	}
+}
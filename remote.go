@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// generatedHeadersRegexp matches the outputs that must exist locally for
+// the entries of the database to resolve their includes.
+const generatedHeadersRegexp = `.*\.(h|hh|hpp|hxx|inc|inl|ipp|tcc|def|modulemap)$`
+
+// remoteDownloadFlags are flags that keep the outputs of remotely executed
+// actions from being downloaded.
+var remoteDownloadFlags = []string{
+	"--remote_download_minimal",
+	"--remote_download_toplevel",
+	"--remote_download_outputs=minimal",
+	"--remote_download_outputs=toplevel",
+}
+
+// bazelrcFiles returns the rc files Bazel reads for the workspace, in the
+// order it reads them.
+func bazelrcFiles() []string {
+	system := "/etc/bazel.bazelrc"
+	if runtime.GOOS == "windows" {
+		system = filepath.Join(os.Getenv("ProgramData"), "bazel.bazelrc")
+	}
+	files := []string{system, filepath.Join(workspace, ".bazelrc")}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".bazelrc"))
+	}
+	return files
+}
+
+// bazelrcOptions returns the options of every `build` or `common` line, and
+// of `build:<config>` lines, from the rc files and the files they import.
+// The options are keyed by config, "" for lines without one.
+func bazelrcOptions() map[string][]string {
+	options := map[string][]string{}
+	seen := map[string]bool{}
+	var read func(name string)
+	read = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		f, err := os.Open(name)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		scn := bufio.NewScanner(f)
+		for scn.Scan() {
+			fields := strings.Fields(scn.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if (fields[0] == "import" || fields[0] == "try-import") && len(fields) > 1 {
+				read(strings.ReplaceAll(fields[1], "%workspace%", workspace))
+				continue
+			}
+			parts := strings.SplitN(fields[0], ":", 2)
+			if parts[0] != "build" && parts[0] != "common" {
+				continue
+			}
+			config := ""
+			if len(parts) == 2 {
+				config = parts[1]
+			}
+			options[config] = append(options[config], fields[1:]...)
+		}
+	}
+	for _, name := range bazelrcFiles() {
+		read(name)
+	}
+	return options
+}
+
+// expandConfigs returns flags with the options of every --config they
+// select, recursively, from the rc files.
+func expandConfigs(flags []string, rc map[string][]string) []string {
+	var expanded []string
+	seen := map[string]bool{}
+	var expand func(flags []string)
+	expand = func(flags []string) {
+		for i := 0; i < len(flags); i++ {
+			flag := flags[i]
+			var config string
+			switch {
+			case strings.HasPrefix(flag, "--config="):
+				config = strings.TrimPrefix(flag, "--config=")
+			case flag == "--config" && i+1 < len(flags):
+				i++
+				config = flags[i]
+			default:
+				expanded = append(expanded, flag)
+				continue
+			}
+			if !seen[config] {
+				seen[config] = true
+				expand(rc[config])
+			}
+		}
+	}
+	expand(append(append([]string{}, rc[""]...), flags...))
+	return expanded
+}
+
+// usesMinimalDownloads reports whether bazel is configured, in the rc files
+// or by the flags of cfg, to leave the outputs of remote actions remote.
+// Bazel 7 and newer do so by default when there is a remote cache or
+// executor.
+func usesMinimalDownloads(cfg bazelConfig) bool {
+	minimal := !bazel.less(7, 0)
+	remote := false
+	for _, flag := range expandConfigs(analysisFlags(cfg), bazelrcOptions()) {
+		for _, f := range remoteDownloadFlags {
+			if flag == f {
+				minimal = true
+			}
+		}
+		if flag == "--remote_download_all" || flag == "--remote_download_outputs=all" {
+			minimal = false
+		}
+		for _, f := range []string{"--remote_cache", "--remote_executor"} {
+			if flag == f || strings.HasPrefix(flag, f+"=") {
+				remote = flag != f+"="
+			}
+		}
+	}
+	return minimal && remote
+}
+
+// downloadGeneratedHeaders builds the compilation prerequisites of the
+// targets with their generated headers downloaded, so the includes of the
+// database resolve even when the outputs of remote actions are otherwise
+// left remote.
+func downloadGeneratedHeaders(cfg bazelConfig, labels []string) {
+	var regexFlag string
+	switch {
+	case !bazel.less(7, 0):
+		regexFlag = "--remote_download_regex=" + generatedHeadersRegexp
+	case !bazel.less(6, 0):
+		regexFlag = "--experimental_remote_download_regex=" + generatedHeadersRegexp
+	default:
+		fmt.Fprintf(os.Stderr, "warning: Bazel %s can't download generated headers of remote actions, includes of generated headers won't resolve\n", bazel)
+		return
+	}
+	if len(labels) == 0 {
+		return
+	}
+	fmt.Println("downloading generated headers")
+	args := append([]string{"build", "--keep_going", "--output_groups=" + compilationPrerequisites}, analysisFlags(cfg)...)
+	args = append(append(args, regexFlag, "--"), labels...)
+	cmd := bazelCommand(args...)
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to download generated headers: %s\n", err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// With bzlmod, the directories of external repositories are named by their
+// canonical name, e.g. `rules_cc~` (Bazel 7), `rules_cc+` (Bazel 8) or
+// `_main~ext~foo` for repositories created by module extensions. Canonical
+// names are separated into their parts by these characters.
+const canonicalRepoSeparators = "~+"
+
+// canonical repository names by the name used to refer to them in exec paths
+var canonicalRepos = map[string]string{}
+
+// repoMapping maps the apparent repository names visible from the main
+// repository to their canonical names. It is empty when bzlmod is disabled.
+var repoMapping = map[string]string{}
+
+// loadRepoMapping reads the repository mapping of the main repository with
+// `bazel mod dump_repo_mapping`, which exists since Bazel 7.1. Failures are
+// ignored since they are expected when bzlmod is disabled.
+func loadRepoMapping() {
+	if bazel.less(7, 1) {
+		return
+	}
+	out := new(strings.Builder)
+	cmd := exec.Command(bazelBinary(), "mod", "dump_repo_mapping", "")
+	cmd.Stdout = out
+	cmd.Dir = workspace
+	if err := cmd.Run(); err != nil {
+		return
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(out.String()), &mapping); err != nil {
+		return
+	}
+	repoMapping = mapping
+}
+
+// mainRepoLabel strips the repository from labels of the main repository,
+// which Bazel spells //pkg, @//pkg or @@//pkg depending on version and
+// context.
+func mainRepoLabel(label string) string {
+	for _, prefix := range []string{"@@//", "@//", "@@_main//"} {
+		if strings.HasPrefix(label, prefix) {
+			return label[len(prefix)-2:]
+		}
+	}
+	return label
+}
+
+// apparentLabel returns label with its canonical repository name, if any,
+// replaced by the apparent name used in the main repository's BUILD files.
+func apparentLabel(label string) string {
+	if !strings.HasPrefix(label, "@@") {
+		return label
+	}
+	i := strings.Index(label, "//")
+	if i < 0 {
+		return label
+	}
+	repo, rest := label[2:i], label[i:]
+	if repo == "" || repo == "_main" {
+		return rest
+	}
+	// several apparent names may map to the same repository, prefer the
+	// shortest so the result is stable
+	var best string
+	for apparent, canonical := range repoMapping {
+		if canonical != repo || apparent == "" {
+			continue
+		}
+		if best == "" || len(apparent) < len(best) ||
+			(len(apparent) == len(best) && apparent < best) {
+			best = apparent
+		}
+	}
+	if best == "" {
+		return label
+	}
+	return "@" + best + rest
+}
+
+// directory names under output_base/external, read on first use
+var externalRepoDirs []string
+
+func listExternalRepoDirs() []string {
+	if externalRepoDirs != nil {
+		return externalRepoDirs
+	}
+	externalRepoDirs = []string{}
+	entries, err := os.ReadDir(path.Join(outputBaseDir, "external"))
+	if err != nil {
+		return externalRepoDirs
+	}
+	for _, e := range entries {
+		if e.IsDir() || isLink(e.Type()) {
+			externalRepoDirs = append(externalRepoDirs, e.Name())
+		}
+	}
+	return externalRepoDirs
+}
+
+// isCanonicalRepoOf reports whether canonical is a canonical repository name
+// for the repository called name: name itself followed by a separator and an
+// optional version, or name as the last part of an extension repository.
+func isCanonicalRepoOf(canonical, name string) bool {
+	if canonical == name {
+		return true
+	}
+	if strings.HasPrefix(canonical, name) {
+		rest := canonical[len(name):]
+		if strings.IndexAny(rest[:1], canonicalRepoSeparators) == 0 &&
+			!strings.ContainsAny(rest[1:], canonicalRepoSeparators) {
+			return true
+		}
+	}
+	i := strings.LastIndexAny(canonical, canonicalRepoSeparators)
+	return i > 0 && canonical[i+1:] == name
+}
+
+// canonicalRepo returns the name of the directory under output_base/external
+// that holds the repository referred to as name. name is returned as is when
+// it already exists or no single canonical repository matches it.
+func canonicalRepo(name string) string {
+	if c, ok := canonicalRepos[name]; ok {
+		return c
+	}
+	c := name
+	if mapped, ok := repoMapping[name]; ok && mapped != "" {
+		c = mapped
+	} else if _, err := os.Stat(path.Join(outputBaseDir, "external", name)); err != nil {
+		var matches []string
+		for _, dir := range listExternalRepoDirs() {
+			if isCanonicalRepoOf(dir, name) {
+				matches = append(matches, dir)
+			}
+		}
+		if len(matches) == 1 {
+			c = matches[0]
+		}
+	}
+	canonicalRepos[name] = c
+	return c
+}
+
+// files Bazel generates in the directory of a local repository, next to the
+// symlinks to the local directory's entries
+var generatedRepoFiles = map[string]bool{
+	"BUILD":           true,
+	"BUILD.bazel":     true,
+	"REPO.bazel":      true,
+	"WORKSPACE":       true,
+	"WORKSPACE.bazel": true,
+}
+
+// local directories of repositories by canonical name, "" for repositories
+// that aren't local
+var localRepos = map[string]string{}
+
+// localRepoPath returns the directory that a local_repository, a
+// local_path_override or an --override_repository points the canonical
+// repository at, or "" if it isn't local. Bazel represents these either as a
+// symlink to the directory or as a directory of symlinks to each of its
+// entries.
+func localRepoPath(canonical string) string {
+	if dir, ok := localRepos[canonical]; ok {
+		return dir
+	}
+	localRepos[canonical] = ""
+
+	repo := path.Join(outputBaseDir, "external", canonical)
+	info, err := os.Lstat(repo)
+	if err != nil {
+		return ""
+	}
+	if isLink(info.Mode()) {
+		dir, err := filepath.EvalSymlinks(repo)
+		if err != nil {
+			return ""
+		}
+		dir = toSlash(dir)
+		localRepos[canonical] = dir
+		return dir
+	}
+
+	entries, err := os.ReadDir(repo)
+	if err != nil {
+		return ""
+	}
+	var dir string
+	for _, e := range entries {
+		if !isLink(e.Type()) {
+			if generatedRepoFiles[e.Name()] {
+				continue
+			}
+			// a fetched repository
+			return ""
+		}
+		target, err := readLink(path.Join(repo, e.Name()))
+		if err != nil || !filepath.IsAbs(target) || filepath.Base(target) != e.Name() {
+			return ""
+		}
+		parent := toSlash(filepath.Dir(target))
+		if dir != "" && parent != dir {
+			return ""
+		}
+		dir = parent
+	}
+	localRepos[canonical] = dir
+	return dir
+}
+
+// externalPath resolves p, an exec path of the form external/<repo>/..., to
+// the canonical repository directory under the output base, or to the local
+// directory the repository is overridden with.
+func externalPath(p string) string {
+	parts := strings.SplitN(path.Clean(p), "/", 3)
+	if len(parts) < 2 {
+		return path.Join(outputBaseDir, p)
+	}
+	parts[1] = canonicalRepo(strings.TrimPrefix(parts[1], "@"))
+	if dir := localRepoPath(parts[1]); dir != "" {
+		return path.Join(append([]string{dir}, parts[2:]...)...)
+	}
+	return path.Join(append([]string{outputBaseDir}, parts...)...)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestApparentLabel(t *testing.T) {
+	defer func(w string, m map[string]string) { workspace, repoMapping = w, m }(workspace, repoMapping)
+	repoMapping = map[string]string{
+		"":      "",
+		"zlib":  "zlib+",
+		"z":     "zlib+",
+		"proto": "protobuf+",
+	}
+	root := saveWorkspace()
+
+	// the databases of other workspaces are generated before the labels of
+	// the root workspace's entries are written
+	setWorkspace(t.TempDir())
+	restoreWorkspace(root)
+
+	tests := []struct {
+		label, want string
+	}{
+		{"//app:main", "//app:main"},
+		{"@@//app:main", "//app:main"},
+		{"@@_main//app:main", "//app:main"},
+		{"@@zlib+//:zlib", "@z//:zlib"},
+		{"@@protobuf+//src:protobuf", "@proto//src:protobuf"},
+		{"@@rules_cc+//cc:toolchain", "@@rules_cc+//cc:toolchain"},
+		{"@zlib//:zlib", "@zlib//:zlib"},
+	}
+	for _, tt := range tests {
+		if got := apparentLabel(tt.label); got != tt.want {
+			t.Errorf("apparentLabel(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// resource directories of consumer compilers, once probed
+var resourceDirs = map[string]string{}
+
+// consumerClang returns the clang the consumer of the database uses in place
+// of the build's compiler: the path given to --clang-compat, or else the
+// --compiler substituted for the compiler of the actions, or "" if neither
+// is set.
+func consumerClang() string {
+	if _, ok := clangMajorVersion(*clangCompat); *clangCompat != "" && !ok {
+		return *clangCompat
+	}
+	if *compilerFlag != "action" {
+		return *compilerFlag
+	}
+	return ""
+}
+
+// addResourceDir adds the -resource-dir of the consumer clang, which holds
+// builtin headers like stddef.h and immintrin.h, according to
+// --resource-dir: `auto` probes it with -print-resource-dir when the
+// consumer's clang isn't the build's compiler, `none` adds nothing, and any
+// other value is the directory to use. Compilers other than clang, like a
+// --compiler=g++, aren't probed.
+func addResourceDir(a *compileAction, args []string) []string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-resource-dir") {
+			return args
+		}
+	}
+	dir := *resourceDir
+	switch dir {
+	case "none":
+		return args
+	case "auto":
+		clang := consumerClang()
+		if !strings.Contains(compilerName(clang), "clang") || isClangCL(clang) {
+			return args
+		}
+		if dir = probeResourceDir(clang); dir == "" {
+			return args
+		}
+	}
+	return append(append([]string{}, args...), "-resource-dir="+dir)
+}
+
+// probeResourceDir returns the resource directory of clang, or "" with a
+// warning if it can't be probed.
+func probeResourceDir(clang string) string {
+	if dir, ok := resourceDirs[clang]; ok {
+		return dir
+	}
+	var dir string
+	if out, err := exec.Command(clang, "-print-resource-dir").Output(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to probe the resource directory of %s, entries get none: %s\n", clang, err)
+	} else {
+		dir = toSlash(strings.TrimSpace(string(out)))
+	}
+	resourceDirs[clang] = dir
+	return dir
+}
@@ -0,0 +1,85 @@
+package main
+
+// argRewrite is a named transformation of the arguments of a compile
+// action, which start with the compiler.
+type argRewrite struct {
+	name  string
+	apply func(a *compileAction, args []string) []string
+}
+
+// argRewrites returns the rewrites applied to every compile action, in
+// order, after its paths are resolved.
+func argRewrites() []argRewrite {
+	return []argRewrite{
+		{"profile", applyTranslationProfile},
+		{"gcc-flags", translateGCCFlags},
+		{"c-flags", dropCXXFlags},
+		{"virtual-includes", resolveVirtualIncludes},
+		{"external-isystem", externalIncludesAsSystem},
+		{"module-maps", applyModuleMapPolicy},
+		{"pch", substitutePCH},
+		{"instrumentation", applyInstrumentationPolicy},
+		{"determinism", stripDeterminismFlags},
+		{"resource-dir", addResourceDir},
+		{"system-includes", addBuiltinIncludes},
+		{"dedup", dedupFlags},
+		{"clang-compat", dropUnsupportedClangFlags},
+	}
+}
+
+// argChange is what an argument rewrite changed in the arguments of an
+// action.
+type argChange struct {
+	rewrite        string
+	added, removed []string
+}
+
+// rewriteArgs applies the argument rewrites to args, recording the ones that
+// changed them, and what they changed, in a.
+func rewriteArgs(a *compileAction, args []string) []string {
+	for _, r := range argRewrites() {
+		rewritten := r.apply(a, args)
+		if !equalArgs(args, rewritten) {
+			a.rewrites = append(a.rewrites, r.name)
+			added, removed := diffArgs(args, rewritten)
+			a.changes = append(a.changes, argChange{r.name, added, removed})
+		}
+		args = rewritten
+	}
+	return args
+}
+
+// diffArgs returns the arguments of after that aren't in before, and the
+// ones of before that aren't in after, counting repeated arguments.
+func diffArgs(before, after []string) (added, removed []string) {
+	counts := map[string]int{}
+	for _, arg := range before {
+		counts[arg]++
+	}
+	for _, arg := range after {
+		if counts[arg] > 0 {
+			counts[arg]--
+		} else {
+			added = append(added, arg)
+		}
+	}
+	for _, arg := range before {
+		if counts[arg] > 0 {
+			counts[arg]--
+			removed = append(removed, arg)
+		}
+	}
+	return added, removed
+}
+
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// spawnWrappers are the tools Bazel's spawn strategies run actions with,
+// by the flags they take a value with. Their own flags end at the compiler
+// or a "--" argument.
+var spawnWrappers = map[string]map[string]bool{
+	"process-wrapper": {},
+	"linux-sandbox": {
+		"-W": true, "-T": true, "-t": true, "-l": true, "-L": true,
+		"-w": true, "-e": true, "-M": true, "-m": true, "-S": true,
+		"-h": true, "-D": true,
+	},
+	"sandbox-exec": {"-f": true, "-n": true, "-p": true, "-D": true},
+}
+
+// stripSpawnWrapper drops a spawn wrapper and its flags from the front of
+// args, as they show in the commands of some spawn strategies.
+func stripSpawnWrapper(args []string) ([]string, bool) {
+	valueFlags, ok := spawnWrappers[compilerName(args[0])]
+	if !ok || len(args) < 2 {
+		return args, false
+	}
+	args = args[1:]
+	for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		args = args[1:]
+		if flag == "--" {
+			break
+		}
+		if valueFlags[flag] && len(args) > 1 {
+			args = args[1:]
+		}
+	}
+	return args, true
+}
+
+// sandboxExecRootRegexp matches the execution root of a sandboxed action,
+// like <output_base>/sandbox/linux-sandbox/12/execroot/_main/, or the
+// /proc/self/cwd/ that stands for the execution root in actions run with
+// PWD=/proc/self/cwd.
+var sandboxExecRootRegexp = regexp.MustCompile(`(?:(?:[A-Za-z]:)?/[^"'=:,]*?/sandbox/[\w-]+/\d+/execroot/[^/\s"']+|/proc/self/cwd)/`)
+
+// stripSandboxPaths makes the paths into the execution root of a sandbox
+// valid outside of it: relative to the execution root when they make up an
+// argument or the value of a path flag, which resolves them like the other
+// exec paths, and absolute in the execution root elsewhere.
+func stripSandboxPaths(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		var b strings.Builder
+		end := 0
+		for _, m := range sandboxExecRootRegexp.FindAllStringIndex(arg, -1) {
+			b.WriteString(arg[end:m[0]])
+			if m[0] > 0 && !isPathFlag(arg[:m[0]]) {
+				b.WriteString(executionRoot + "/")
+			}
+			end = m[1]
+		}
+		b.WriteString(arg[end:])
+		out[i] = b.String()
+	}
+	return out
+}
+
+// isPathFlag reports whether prefix is an attached path flag of pathFlags.
+func isPathFlag(prefix string) bool {
+	for _, f := range pathFlags {
+		if f.attached && f.flag == prefix {
+			return true
+		}
+	}
+	return false
+}
@@ -1,18 +1,33 @@
 # A formatting function for Bazel cquery results
 #
-# Formats the target as the path of its first file, if that file has a C++ extension..
+# Formats the target as the path of its first file, if that file is a source
+# file with a C++ extension. The files of aliases are the ones of their actual
+# target.
 
 _extensions = [
+    "c",
     "cc",
     "cpp",
+    "cppm",
+    "c++m",
+    "ccm",
+    "cxxm",
+    "ixx",
     "cxx",
     "h",
     "hh",
     "hpp",
     "hxx",
     "ipp",
+    "inc",
+    "inl",
+    "def",
+    "tcc",
+    "tpp",
     "m",
     "mm",
+    "S",
+    "s",
 ]
 
 def format(target):
@@ -20,6 +35,6 @@ def format(target):
     if len(files) == 0:
         return ""
     f = files[0]
-    if f.extension not in _extensions:
+    if not f.is_source or f.extension not in _extensions:
         return ""
     return f.path
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// swiftWorkerFlagPrefix is the prefix of the flags rules_swift passes to its
+// worker rather than to swiftc.
+const swiftWorkerFlagPrefix = "-Xwrapped-swift="
+
+// swiftPathFlags are the swiftc flags that take a path, besides pathFlags.
+var swiftPathFlags = []pathFlag{
+	{"-Fsystem", false},
+}
+
+// generateSwift collects the compile commands of the SwiftCompile actions of
+// the universe in cfg, for SourceKit-LSP. Every Swift source of an action
+// gets an entry with the whole swiftc invocation, which compiles the module
+// at once.
+func generateSwift(cfg bazelConfig) []compileCommand {
+	g := aquery(cfg, fmt.Sprintf(`mnemonic("SwiftCompile", %s)`, universe()))
+	flags := append(append([]pathFlag{}, swiftPathFlags...), pathFlags...)
+	var compileCommands []compileCommand
+	for _, action := range g.Actions {
+		if action.Mnemonic != "SwiftCompile" {
+			continue
+		}
+		label, ok := g.label(action)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "skipping SwiftCompile action %q of missing target (%d)\n", action.ActionKey, action.TargetID)
+			continue
+		}
+		if isIgnoredLabel(label) {
+			continue
+		}
+		if isExecConfiguration(g.configuration(action)) && !*includeExecConfiguration {
+			continue
+		}
+		args := swiftcArgs(stripSandboxPaths(g.expandParamFiles(action)))
+		if args == nil {
+			fmt.Fprintf(os.Stderr, "skipping SwiftCompile action %q without swiftc\n", action.ActionKey)
+			continue
+		}
+		env := actionEnv(action)
+		args = substituteXcodePlaceholders(g.rewritePaths(args, flags), env)
+		for _, arg := range args[1:] {
+			if path.Ext(arg) != ".swift" {
+				continue
+			}
+			compileCommands = append(compileCommands, compileCommand{
+				Directory: entryDirectory(),
+				File:      entryFile(arg),
+				Arguments: args,
+				env:       env,
+			})
+		}
+	}
+	return compileCommands
+}
+
+// swiftcArgs returns the arguments of a SwiftCompile action from swiftc on,
+// dropping the worker of rules_swift in front of it and the flags meant for
+// the worker, or nil if the action doesn't run swiftc.
+func swiftcArgs(args []string) []string {
+	for i, arg := range args {
+		if strings.TrimSuffix(path.Base(arg), ".exe") != "swiftc" {
+			continue
+		}
+		return filterArgs(args[i:], func(arg string) bool {
+			return !strings.HasPrefix(arg, swiftWorkerFlagPrefix)
+		})
+	}
+	return nil
+}
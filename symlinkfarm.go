@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// symlinkFarmDir is the directory of the symlink farm in the workspace.
+const symlinkFarmDir = ".compile_commands"
+
+// symlinkFarmRules are the rules mapping the paths of the entries of the
+// current workspace into its symlink farm, once created.
+var symlinkFarmRules []string
+
+// createSymlinkFarm creates a symlink farm in the workspace ws with
+// --symlink-farm, for tools that refuse paths outside of the project: a
+// symlink to the bazel-out of ws, and one to each external repository the
+// entries of any of databases, the ones of ws, name under external. It's
+// created once for all of them, before they are written, and sets
+// symlinkFarmRules.
+func createSymlinkFarm(ws *workspaceState, databases []database) {
+	symlinkFarmRules = nil
+	if !*symlinkFarm {
+		return
+	}
+	if !isIgnored(symlinkFarmDir) {
+		fmt.Fprintf(os.Stderr, "warning: add %s to .bazelignore, or Bazel loads the external repositories linked in it as packages of the workspace\n", symlinkFarmDir)
+	}
+	farm := path.Join(ws.dir, symlinkFarmDir)
+	external := path.Join(farm, "external")
+	if err := os.MkdirAll(external, 0755); err != nil {
+		panic(fmt.Errorf("failed to create symlink farm: %s", err))
+	}
+
+	// links of repositories that aren't named anymore would be stale
+	if entries, err := os.ReadDir(external); err == nil {
+		for _, e := range entries {
+			if isLink(e.Type()) {
+				os.Remove(path.Join(external, e.Name()))
+			}
+		}
+	}
+
+	link := func(target, name string) {
+		os.Remove(name)
+		if err := os.Symlink(target, name); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to create symlink farm: %s\n", err)
+			return
+		}
+		symlinkFarmRules = append(symlinkFarmRules, target+"="+name)
+	}
+	link(path.Join(ws.executionRoot, "bazel-out"), path.Join(farm, "bazel-out"))
+	var commands []compileCommand
+	for _, db := range databases {
+		commands = append(commands, db.commands...)
+	}
+	for _, repo := range externalRepos(commands, ws.outputBaseDir) {
+		link(path.Join(ws.outputBaseDir, "external", repo), path.Join(external, repo))
+	}
+}
+
+// externalRepos returns the directories of the external repositories under
+// the output base outputBase that the entries name, sorted.
+func externalRepos(compileCommands []compileCommand, outputBase string) []string {
+	prefix := outputBase + "/external/"
+	found := map[string]bool{}
+	find := func(s string) {
+		for {
+			i := strings.Index(s, prefix)
+			if i < 0 {
+				return
+			}
+			s = s[i+len(prefix):]
+			end := strings.IndexAny(s, "/ \"',;:=")
+			if end < 0 {
+				end = len(s)
+			}
+			if end > 0 {
+				found[s[:end]] = true
+			}
+		}
+	}
+	for _, c := range compileCommands {
+		find(c.File)
+		find(c.Command)
+		for _, arg := range c.Arguments {
+			find(arg)
+		}
+	}
+	repos := make([]string, 0, len(found))
+	for repo := range found {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// toolchainFlags are the flags that change the builtin include directories
+// of a compiler, and whether they take a value in the next argument.
+var toolchainFlags = []struct {
+	flag     string
+	separate bool
+}{
+	{"--target=", false},
+	{"-target", true},
+	{"--sysroot=", false},
+	{"--sysroot", true},
+	{"-isysroot", true},
+	{"--gcc-toolchain=", false},
+	{"-stdlib=", false},
+	{"-nostdinc", false},
+	{"-nostdlibinc", false},
+	{"-arch", true},
+	{"-m32", false},
+	{"-m64", false},
+}
+
+// builtin include flags by compiler and toolchain flags, once probed
+var builtinIncludes = map[string][]string{}
+
+// addBuiltinIncludes appends the builtin include directories of the action's
+// compiler to args with --system-includes, so that the standard library
+// resolves even when the consumer's clang doesn't know the hermetic or cross
+// toolchain of the build.
+func addBuiltinIncludes(a *compileAction, args []string) []string {
+	if !*systemIncludes || a.compiler == "" {
+		return args
+	}
+	return append(append([]string{}, args...), compilerBuiltinIncludes(a, args, nil)...)
+}
+
+// compilerBuiltinIncludes returns the flags adding the builtin include
+// directories of the action's compiler, given the toolchain flags of args
+// and the ones keep selects, like the -m flags choosing GCC's multilib.
+func compilerBuiltinIncludes(a *compileAction, args []string, keep func(arg string) bool) []string {
+	lang := a.language
+	if _, ok := languagePrecedence[lang]; !ok || strings.HasPrefix(lang, "assembler") {
+		lang = "c++"
+	}
+	probe := []string{"-E", "-v", "-x", lang}
+	for i := 1; i < len(args); i++ {
+		if keep != nil && keep(args[i]) {
+			probe = append(probe, args[i])
+			continue
+		}
+		for _, f := range toolchainFlags {
+			switch {
+			case f.separate && args[i] == f.flag && i+1 < len(args):
+				probe = append(probe, args[i], args[i+1])
+				i++
+			case !f.separate && strings.HasPrefix(args[i], f.flag):
+				probe = append(probe, args[i])
+			default:
+				continue
+			}
+			break
+		}
+	}
+	probe = append(probe, "-")
+	key := a.compiler + "\x00" + strings.Join(probe, "\x00")
+	includes, ok := builtinIncludes[key]
+	if !ok {
+		includes = probeBuiltinIncludes(a.compiler, probe)
+		builtinIncludes[key] = includes
+	}
+	return includes
+}
+
+// probeBuiltinIncludes runs compiler with args, which make it print its
+// include search list, and returns the flags adding the directories of the
+// list, -iframework for framework directories and -isystem for the others.
+func probeBuiltinIncludes(compiler string, args []string) []string {
+	out := new(strings.Builder)
+	cmd := exec.Command(compiler, args...)
+	cmd.Dir = executionRoot
+	cmd.Stdin = strings.NewReader("")
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to probe the builtin include directories of %s: %s\n", compiler, err)
+		return nil
+	}
+	var includes []string
+	in := false
+	scn := bufio.NewScanner(strings.NewReader(out.String()))
+	for scn.Scan() {
+		line := scn.Text()
+		switch {
+		case strings.HasPrefix(line, "#include <...> search starts here:"):
+			in = true
+		case strings.HasPrefix(line, "End of search list."):
+			in = false
+		case in:
+			dir := strings.TrimSpace(line)
+			if d := strings.TrimSuffix(dir, " (framework directory)"); d != dir {
+				includes = append(includes, "-iframework", execPath(d))
+			} else {
+				includes = append(includes, "-isystem", execPath(dir))
+			}
+		}
+	}
+	return includes
+}
@@ -0,0 +1,35 @@
+package main
+
+// targetEntry is a target compiling a file of the database, in targets.json.
+type targetEntry struct {
+	Label         string `json:"label"`
+	Mnemonic      string `json:"mnemonic,omitempty"`
+	Configuration string `json:"configuration,omitempty"`
+}
+
+// writeTargetsSidecar writes the targets of the entries of a database, keyed
+// by the file of the entries, to the named file in the workspace. Editor
+// plugins use it to jump to the BUILD file of a file, or to build its
+// target. Files of targets without compile actions have no mnemonic and
+// configuration.
+func writeTargetsSidecar(name string, compileCommands []compileCommand) {
+	targets := map[string][]targetEntry{}
+	for _, c := range compileCommands {
+		if c.File == "" || c.label == "" {
+			continue
+		}
+		t := targetEntry{Label: apparentLabel(c.label)}
+		if c.action != nil {
+			t.Mnemonic = c.action.mnemonic
+			t.Configuration = c.action.configuration
+		}
+		found := false
+		for _, other := range targets[c.File] {
+			found = found || other == t
+		}
+		if !found {
+			targets[c.File] = append(targets[c.File], t)
+		}
+	}
+	writeJSON(name, targets)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// external repositories whose toolchain was checked, by directory name
+var checkedToolchainRepos = map[string]bool{}
+
+// fetchToolchainRepo fetches the external repository of compiler, a resolved
+// path, if it's missing, with --fetch-toolchains. Hermetic toolchains like
+// toolchains_llvm are only fetched when a build needs them, so their compiler
+// and headers are missing after a `bazel clean --expunge`.
+func fetchToolchainRepo(compiler string) {
+	rest := strings.TrimPrefix(compiler, outputBaseDir+"/external/")
+	if !*fetchToolchains || rest == compiler {
+		return
+	}
+	repo := strings.SplitN(rest, "/", 2)[0]
+	if checkedToolchainRepos[repo] {
+		return
+	}
+	checkedToolchainRepos[repo] = true
+	if _, err := os.Stat(compiler); err == nil {
+		return
+	}
+
+	args := []string{"sync", "--only=" + repo}
+	if !bazel.less(7, 1) {
+		args = []string{"fetch", "--repo=@@" + repo}
+	}
+	fmt.Printf("fetching toolchain repository %s\n", repo)
+	cmd := bazelCommand(args...)
+	cmd.Stdout = os.Stderr
+	if err := cmd.Run(); err != nil {
+		panic(fmt.Errorf("failed to fetch toolchain repository %s: %s", repo, err))
+	}
+	// forget what was resolved while the repository was missing
+	canonicalRepos = map[string]string{}
+	externalRepoDirs = nil
+	localRepos = map[string]string{}
+	unwrappedCompilers = map[string]string{}
+}
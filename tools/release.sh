@@ -0,0 +1,42 @@
+#!/usr/bin/env bash
+# Cross-compiles generate_compile_commands for every platform supported by
+# prebuilt.bzl and prints the sha256 of each binary.
+#
+# Usage: tools/release.sh <version>
+#
+# The binaries are written to dist/<version>/ and should be uploaded as assets
+# of the GitHub release tagged <version>. The printed dict can be pasted into
+# the `sha256s` attribute of `generate_compile_commands_prebuilt`.
+
+set -euo pipefail
+
+version="${1:?usage: tools/release.sh <version>}"
+root="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+out="${root}/dist/${version}"
+
+platforms=(
+  darwin_amd64
+  darwin_arm64
+  linux_amd64
+  linux_arm64
+  windows_amd64
+)
+
+mkdir -p "${out}"
+cd "${root}"
+
+echo "sha256s = {"
+for platform in "${platforms[@]}"; do
+  goos="${platform%_*}"
+  goarch="${platform#*_}"
+  ext=""
+  if [[ "${goos}" == "windows" ]]; then
+    ext=".exe"
+  fi
+  bin="${out}/generate_compile_commands_${platform}${ext}"
+  CGO_ENABLED=0 GOOS="${goos}" GOARCH="${goarch}" \
+    go build -trimpath -ldflags="-s -w" -o "${bin}" *.go
+  sum="$(shasum -a 256 "${bin}" | cut -d' ' -f1)"
+  echo "    \"${platform}\": \"${sum}\","
+done
+echo "}"
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// translationUnitExtensions are the extensions of files in a tree artifact
+// that get an entry of their own.
+var translationUnitExtensions = map[string]bool{
+	".c":    true,
+	".cc":   true,
+	".cpp":  true,
+	".cxx":  true,
+	".c++":  true,
+	".C":    true,
+	".m":    true,
+	".mm":   true,
+	".cppm": true,
+	".ixx":  true,
+	".S":    true,
+	".s":    true,
+}
+
+// expandTreeArtifact returns the exec paths of the translation units in the
+// tree artifact dir, an exec path. The tree artifact only exists after it was
+// built, e.g. with --build.
+func expandTreeArtifact(dir string) []string {
+	root := path.Join(executionRoot, dir)
+	if _, err := os.Stat(root); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: tree artifact %s doesn't exist, build it to get its entries: %s\n", dir, err)
+		return nil
+	}
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !translationUnitExtensions[filepath.Ext(p)] {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, path.Join(dir, filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to expand tree artifact %s: %s\n", dir, err)
+	}
+	return files
+}
+
+// actionTemplate is an action template of a target compiling the sources of
+// a tree artifact, which aquery prints without arguments.
+type actionTemplate struct {
+	label         string
+	mnemonic      string
+	configuration string
+	// exec paths of the tree artifact of the sources, and of the objects
+	src    string
+	output string
+	env    map[string]string
+	// exec paths of the generated inputs, the source tree among them, which
+	// --build=required builds when missing. The object tree isn't one: the
+	// compilation prerequisites don't include it.
+	generatedInputs []string
+}
+
+// templateArtifacts returns the exec paths of the tree artifacts a compiles
+// and writes, or "" if it has no tree artifact input. Of several, the source
+// tree is the one of the inputs a lists directly rather than through their
+// dependencies, like the headers.
+func (g *actionGraph) templateArtifacts(a action) (src string, output string) {
+	var trees []string
+	for _, id := range a.InputDepSetIDs {
+		for _, in := range g.depSets[id].DirectArtifactIDs {
+			if g.artifacts[in].IsTreeArtifact {
+				trees = append(trees, g.artifactPath(in))
+			}
+		}
+	}
+	if len(trees) == 0 {
+		for _, in := range g.inputs(a) {
+			if g.isTreeArtifact(in) {
+				trees = append(trees, in)
+			}
+		}
+	}
+	if len(trees) == 0 {
+		return "", ""
+	}
+	for _, id := range append([]aqueryID{a.PrimaryOutputID}, a.OutputIDs...) {
+		if id != 0 && g.artifacts[id].IsTreeArtifact {
+			output = g.artifactPath(id)
+			break
+		}
+	}
+	return trees[0], output
+}
+
+// addTemplateActions adds a compile action for each of templates to the
+// target of ccTargets it belongs to, with the arguments of the target's
+// other compile actions. Templates of targets compiling nothing else are
+// left out, as there are no arguments to give their sources.
+func addTemplateActions(ccTargets map[string]*ccTarget, templates []actionTemplate) {
+	sort.SliceStable(templates, func(i, j int) bool {
+		return templates[i].label < templates[j].label
+	})
+	for _, tpl := range templates {
+		t, ok := ccTargets[tpl.label]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "warning: skipping tree artifact %s of %s, which has no other compile action to take arguments from\n", tpl.src, apparentLabel(tpl.label))
+			continue
+		}
+		t.actions[tpl.src] = append(t.actions[tpl.src], &compileAction{
+			mnemonic:      tpl.mnemonic,
+			compiler:      t.action.compiler,
+			configuration: tpl.configuration,
+			arch:          t.action.arch,
+			src:           tpl.src,
+			output:        tpl.output,
+			args:          t.args,
+			language:      t.language,
+			tree:          true,
+			rewrites:      t.action.rewrites,
+			changes:       t.action.changes,
+			env:           tpl.env,
+
+			generatedInputs: tpl.generatedInputs,
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// universe returns the query expression of the targets whose compile actions
+// are collected.
+func universe() string {
+	include := []string{*universeExpr}
+	var exclude []string
+	if *targetsFile != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "universe" {
+				panic(fmt.Errorf("--universe and --targets-file can't be used together"))
+			}
+		})
+		include, exclude = readTargetsFile(*targetsFile)
+		if len(include) == 0 {
+			include = []string{"//..."}
+		}
+	}
+	include = append(include, alsoPatterns...)
+
+	expr := strings.Join(include, " + ")
+	for _, e := range exclude {
+		expr += " - " + e
+	}
+	if *includeExternalSources {
+		// the labels of external repositories, but not the @// and @@//
+		// labels of the main repository
+		expr = fmt.Sprintf(`%s + filter("^@@?[^/]", deps(%s))`, expr, expr)
+	}
+	if len(includeTags) > 0 {
+		expr = fmt.Sprintf(`attr("tags", "%s", %s)`, tagsRegexp(includeTags), expr)
+	}
+	if len(excludeTags) > 0 {
+		expr = fmt.Sprintf(`(%s) - attr("tags", "%s", %s)`, expr, tagsRegexp(excludeTags), expr)
+	}
+	if len(kinds) > 0 {
+		expr = fmt.Sprintf(`kind("%s", %s)`, kindsRegexp(kinds), expr)
+	}
+	if len(excludeKinds) > 0 {
+		expr = fmt.Sprintf(`(%s) - kind("%s", %s)`, expr, kindsRegexp(excludeKinds), expr)
+	}
+	switch {
+	case *onlyTests && *noTests:
+		panic(fmt.Errorf("--only-tests and --no-tests can't be used together"))
+	case *onlyTests:
+		// the tests and the libraries of the universe they depend on
+		expr = fmt.Sprintf(`(%s) ^ deps(tests(%s))`, expr, expr)
+	case *noTests:
+		expr = fmt.Sprintf(`(%s) - tests(%s)`, expr, expr)
+	}
+	return expr
+}
+
+// withSharedLibraryDeps adds the libraries linked into the cc_shared_library
+// targets of expr to it. cc_shared_library has no compile actions of its own,
+// so without them a universe naming one would contain no sources at all.
+func withSharedLibraryDeps(expr string) string {
+	return fmt.Sprintf(`%s + labels(deps, kind("cc_shared_library rule", %s))`, expr, expr)
+}
+
+// sourceDeps returns the query expression of the dependencies of label that
+// its sources are looked up in, according to --sources. With direct, these
+// are the files label names itself, like its srcs and hdrs, so that every
+// source gets the arguments of its own target. With transitive, the sources
+// of its dependencies are included too, which gives the headers of
+// dependencies outside of the universe entries. Shared libraries reached
+// through dynamic_deps are left out: their sources are compiled by their own
+// targets and including them would pull their whole closure into every
+// dependent target.
+func sourceDeps(label string) string {
+	switch *sourcesFlag {
+	case "direct":
+		return fmt.Sprintf(`deps(%s, 1)`, label)
+	case "transitive":
+		return fmt.Sprintf(`deps(%s) - deps(labels(dynamic_deps, deps(%s)))`, label, label)
+	}
+	panic(fmt.Errorf("invalid --sources %q, expected direct or transitive", *sourcesFlag))
+}
+
+// sourceQuery returns the query of the source files of label, following
+// the alias() rules among the sources, which the cquery of their paths
+// formats as the file of their actual target. Files exported from other
+// packages with exports_files are source files of those packages.
+func sourceQuery(label string) string {
+	return fmt.Sprintf(`kind("source file|alias rule", %s)`, sourceDeps(label))
+}
+
+// kindsRegexp returns a regular expression for the kind() query function that
+// matches rules of any of kinds.
+func kindsRegexp(kinds []string) string {
+	quoted := make([]string, len(kinds))
+	for i, k := range kinds {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	return "^(" + strings.Join(quoted, "|") + ") rule$"
+}
+
+// tagsRegexp returns a regular expression for the attr() query function that
+// matches a tags attribute containing any of tags.
+func tagsRegexp(tags []string) string {
+	quoted := make([]string, len(tags))
+	for i, t := range tags {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	return `[\[ ](` + strings.Join(quoted, "|") + `)[,\]]`
+}
+
+// readTargetsFile reads the target patterns listed in name, which is relative
+// to the workspace unless absolute. Blank lines and lines starting with # are
+// ignored, patterns starting with - are returned in exclude.
+func readTargetsFile(name string) (include, exclude []string) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(workspace, name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		panic(fmt.Errorf("failed to open targets file: %s", err))
+	}
+	defer f.Close()
+
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		line := strings.TrimSpace(scn.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "-"):
+			exclude = append(exclude, strings.TrimSpace(line[1:]))
+		default:
+			include = append(include, line)
+		}
+	}
+	if err := scn.Err(); err != nil {
+		panic(fmt.Errorf("failed to read targets file %q: %s", name, err))
+	}
+	return include, exclude
+}
+
+// ignored directories listed in .bazelignore, read on first use
+var bazelignore []string
+
+// ignoredDirs returns the workspace-relative directories listed in the
+// workspace's .bazelignore file.
+func ignoredDirs() []string {
+	if bazelignore != nil {
+		return bazelignore
+	}
+	bazelignore = []string{}
+	f, err := os.Open(filepath.Join(workspace, ".bazelignore"))
+	if err != nil {
+		return bazelignore
+	}
+	defer f.Close()
+
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		line := strings.TrimSpace(scn.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir := strings.Trim(filepath.ToSlash(filepath.Clean(line)), "/")
+		if dir == "." || dir == "" {
+			continue
+		}
+		bazelignore = append(bazelignore, dir)
+	}
+	if err := scn.Err(); err != nil {
+		panic(fmt.Errorf("failed to read .bazelignore: %s", err))
+	}
+	return bazelignore
+}
+
+// isIgnoredLabel reports whether label is a target of the main repository in
+// a package ignored by .bazelignore. These aren't excluded in the query itself
+// since Bazel fails on patterns beneath ignored directories.
+func isIgnoredLabel(label string) bool {
+	label = strings.TrimPrefix(strings.TrimPrefix(label, "@@"), "@")
+	if !strings.HasPrefix(label, "//") {
+		return false
+	}
+	pkg := strings.SplitN(label[2:], ":", 2)[0]
+	return isIgnored(pkg)
+}
+
+// isIgnored reports whether the workspace-relative path p is inside a
+// directory listed in .bazelignore.
+func isIgnored(p string) bool {
+	for _, dir := range ignoredDirs() {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
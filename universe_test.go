@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestSourceQuery(t *testing.T) {
+	defer func(s string) { *sourcesFlag = s }(*sourcesFlag)
+	tests := []struct {
+		sources, label, want string
+	}{
+		// aliases among the sources are kept for the cquery to follow
+		{"direct", "//app:main", `kind("source file|alias rule", deps(//app:main, 1))`},
+		{"transitive", "//app:main", `kind("source file|alias rule", deps(//app:main) - deps(labels(dynamic_deps, deps(//app:main))))`},
+	}
+	for _, tt := range tests {
+		*sourcesFlag = tt.sources
+		if got := sourceQuery(tt.label); got != tt.want {
+			t.Errorf("sourceQuery(%q) with --sources=%s = %q, want %q", tt.label, tt.sources, got, tt.want)
+		}
+	}
+}
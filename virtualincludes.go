@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// virtualIncludes describes the _virtual_includes directory of a target with
+// strip_include_prefix or include_prefix, a tree of symlinks to its headers.
+type virtualIncludes struct {
+	// exec path of the directory the headers are relative to once their
+	// prefix is stripped
+	root string
+	// prefix the headers are included with
+	includePrefix string
+}
+
+// virtual include directories by their path relative to bazel-bin, like
+// pkg/_virtual_includes/name or external/repo/pkg/_virtual_includes/name,
+// once queried
+var virtualIncludeDirs map[string]virtualIncludes
+
+// virtualIncludesRegexp matches a resolved _virtual_includes directory,
+// capturing its configuration directory and its path relative to bazel-bin.
+var virtualIncludesRegexp = regexp.MustCompile(`/bazel-out/([^/]+)/bin/((?:.*/)?_virtual_includes/[^/]+)/?$`)
+
+// queryXML is the subset of `bazel query --output=xml` needed to read the
+// string attributes of rules.
+type queryXML struct {
+	Rules []struct {
+		Name    string `xml:"name,attr"`
+		Strings []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"string"`
+	} `xml:"rule"`
+}
+
+// queryVirtualIncludes queries the targets in the universe and its
+// dependencies that have a _virtual_includes directory.
+func queryVirtualIncludes() map[string]virtualIncludes {
+	out := new(strings.Builder)
+	stderr := new(strings.Builder)
+	cmd := bazelCommand(
+		"query",
+		fmt.Sprintf(`attr(strip_include_prefix, ".+", deps(%[1]s)) + attr(include_prefix, ".+", deps(%[1]s))`, universe()),
+		"--output=xml",
+		"--keep_going",
+	)
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil && out.Len() == 0 {
+		panic(fmt.Errorf("failed to query virtual includes: %s\n\n%s", err, stderr))
+	}
+
+	dirs := map[string]virtualIncludes{}
+	if strings.TrimSpace(out.String()) == "" {
+		return dirs
+	}
+	var result queryXML
+	if err := xml.Unmarshal([]byte(out.String()), &result); err != nil {
+		panic(fmt.Errorf("failed to parse virtual includes query output: %s", err))
+	}
+	for _, r := range result.Rules {
+		repoDir, pkg, name := labelDirs(r.Name)
+		var strip, prefix string
+		for _, s := range r.Strings {
+			switch s.Name {
+			case "strip_include_prefix":
+				strip = s.Value
+			case "include_prefix":
+				prefix = s.Value
+			}
+		}
+		root := path.Join(repoDir, pkg, strip)
+		if strings.HasPrefix(strip, "/") {
+			root = path.Join(repoDir, strip)
+		}
+		dirs[path.Join(repoDir, pkg, "_virtual_includes", name)] = virtualIncludes{
+			root:          root,
+			includePrefix: strings.Trim(prefix, "/"),
+		}
+	}
+	return dirs
+}
+
+// labelDirs splits label into the exec path of its repository, "" for the
+// main repository, its package and its name.
+func labelDirs(label string) (repoDir, pkg, name string) {
+	label = mainRepoLabel(label)
+	if i := strings.Index(label, "//"); i > 0 {
+		repoDir = path.Join("external", canonicalRepo(strings.TrimLeft(label[:i], "@")))
+		label = label[i:]
+	}
+	label = strings.TrimPrefix(label, "//")
+	if i := strings.Index(label, ":"); i >= 0 {
+		return repoDir, label[:i], label[i+1:]
+	}
+	return repoDir, label, path.Base(label)
+}
+
+// resolveVirtualIncludes replaces the include directories of args that are
+// _virtual_includes directories with --resolve-virtual-includes. These only
+// exist after a build and hold symlinks, so the headers' own directory is
+// used instead, followed by its counterpart in bazel-bin for generated
+// headers. Headers of targets with an include_prefix that their directory
+// doesn't end with are mapped under the prefix with a VFS overlay.
+func resolveVirtualIncludes(a *compileAction, args []string) []string {
+	if !*resolveVirtualIncludesFlag {
+		return args
+	}
+	if virtualIncludeDirs == nil {
+		virtualIncludeDirs = queryVirtualIncludes()
+	}
+	out := make([]string, 0, len(args))
+	overlay := false
+	for i := 0; i < len(args); i++ {
+		if flag, dir, separate := includeFlag(args, i); flag != "" {
+			v, cfg, ok := virtualIncludesOf(dir)
+			switch {
+			case ok && v.includePrefix == "":
+				if separate {
+					i++
+				}
+				out = append(out,
+					flag, execPath(v.root),
+					flag, path.Join(executionRoot, "bazel-out", cfg, "bin", v.root),
+				)
+				continue
+			case ok:
+				root := execPath(v.root)
+				if !isAbs(root) {
+					root = path.Join(workspace, root)
+				}
+				virtualIncludeRemaps[path.Join(dir, v.includePrefix)] = root
+				overlay = true
+			}
+		}
+		out = append(out, args[i])
+	}
+	if overlay {
+		out = append(out, "-ivfsoverlay", virtualIncludesOverlayPath())
+	}
+	return out
+}
+
+// virtualIncludesOf returns the virtual includes that dir, a resolved path,
+// is the directory of, and the configuration directory of dir.
+func virtualIncludesOf(dir string) (v virtualIncludes, cfg string, ok bool) {
+	m := virtualIncludesRegexp.FindStringSubmatch(dir)
+	if m == nil {
+		return v, "", false
+	}
+	v, ok = virtualIncludeDirs[m[2]]
+	// a prefix the headers' directory ends with is the same as none
+	if v.includePrefix != "" && (v.root == v.includePrefix || strings.HasSuffix(v.root, "/"+v.includePrefix)) {
+		v.root = path.Clean("./" + strings.TrimSuffix(v.root, v.includePrefix))
+		v.includePrefix = ""
+	}
+	return v, m[1], ok
+}
+
+// directories of headers by the path they are included from through an
+// include_prefix, for the VFS overlay
+var virtualIncludeRemaps = map[string]string{}
+
+// virtualIncludesOverlayPath returns the path of the VFS overlay mapping
+// include prefixes to the headers' directories.
+func virtualIncludesOverlayPath() string {
+	return path.Join(outputBaseDir, "generate_compile_commands", "virtual_includes.yaml")
+}
+
+// writeVirtualIncludesOverlay writes the VFS overlay of the include prefixes
+// seen so far, if any. Overlays are YAML, of which JSON is a subset.
+func writeVirtualIncludesOverlay() {
+	if len(virtualIncludeRemaps) == 0 {
+		return
+	}
+	type remap struct {
+		Type             string `json:"type"`
+		Name             string `json:"name"`
+		ExternalContents string `json:"external-contents"`
+	}
+	var overlay struct {
+		Version int     `json:"version"`
+		Roots   []remap `json:"roots"`
+	}
+	dirs := make([]string, 0, len(virtualIncludeRemaps))
+	for dir := range virtualIncludeRemaps {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		overlay.Roots = append(overlay.Roots, remap{"directory-remap", dir, virtualIncludeRemaps[dir]})
+	}
+	content, err := json.MarshalIndent(&overlay, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	p := virtualIncludesOverlayPath()
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		panic(fmt.Errorf("failed to write VFS overlay: %s", err))
+	}
+	if err := os.WriteFile(p, content, 0644); err != nil {
+		panic(fmt.Errorf("failed to write VFS overlay: %s", err))
+	}
+}
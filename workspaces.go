@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// database is a compilation database and the name of the file in the
+// workspace it's written to.
+type database struct {
+	name     string
+	commands []compileCommand
+	// the workspace the entries were generated in
+	ws *workspaceState
+}
+
+// workspaceRoots returns root followed by the directories of --workspaces,
+// which are relative to root unless absolute.
+func workspaceRoots(root string) []string {
+	roots := []string{root}
+	for _, dir := range extraWorkspaces {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			panic(fmt.Errorf("invalid workspace: %s", err))
+		}
+		roots = append(roots, filepath.Clean(dir))
+	}
+	return roots
+}
+
+// setWorkspace makes dir the workspace the tool operates on and forgets
+// everything known about the previous one, including the caches of its
+// toolchains, which are keyed by paths relative to its execution root.
+func setWorkspace(dir string) {
+	workspace = hostPath(dir)
+	executionRoot = ""
+	outputBaseDir = ""
+	binDir = ""
+	unwrappedCompilers = map[string]string{}
+	resourceDirs = map[string]string{}
+	gccSysroots = map[string]string{}
+	builtinIncludes = map[string][]string{}
+	emscriptenArgs = map[string][]string{}
+	xcodeSDKPaths = map[string]string{}
+	canonicalRepos = map[string]string{}
+	externalRepoDirs = nil
+	localRepos = map[string]string{}
+	repoMapping = map[string]string{}
+	bazelignore = nil
+	virtualIncludeDirs = nil
+	checkedToolchainRepos = map[string]bool{}
+	virtualIncludeRemaps = map[string]string{}
+	dirNames = map[string][]string{}
+	realWorkspace = ""
+}
+
+// workspaceState is what generating the databases of a workspace learned
+// about it, which setWorkspace forgets.
+type workspaceState struct {
+	dir           string
+	executionRoot string
+	outputBaseDir string
+	binDir        string
+	// the apparent labels of the entries are looked up in it
+	repoMapping map[string]string
+}
+
+// saveWorkspace returns the state of the current workspace.
+func saveWorkspace() *workspaceState {
+	return &workspaceState{
+		dir:           workspace,
+		executionRoot: executionRoot,
+		outputBaseDir: outputBaseDir,
+		binDir:        binDir,
+		repoMapping:   repoMapping,
+	}
+}
+
+// restoreWorkspace makes the workspace of s the current one again.
+func restoreWorkspace(s *workspaceState) {
+	setWorkspace(s.dir)
+	executionRoot = s.executionRoot
+	outputBaseDir = s.outputBaseDir
+	binDir = s.binDir
+	repoMapping = s.repoMapping
+}
+
+// appendDatabases appends the entries of dbs to the database of the same
+// name in all, adding the ones all doesn't have yet.
+func appendDatabases(all, dbs []database) []database {
+	for _, db := range dbs {
+		found := false
+		for i := range all {
+			if all[i].name == db.name {
+				all[i].commands = append(all[i].commands, db.commands...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			all = append(all, db)
+		}
+	}
+	return all
+}
+
+// mapDatabases maps the paths of the entries of databases with
+// mapEntryPaths, each in the workspace it was generated in after creating
+// its symlink farm, and merges the databases of the same name of several
+// workspaces. The first database is of the root workspace, which is the
+// current one again afterwards.
+func mapDatabases(databases []database) []database {
+	root := databases[0].ws
+	var mapped []database
+	for i := 0; i < len(databases); {
+		ws := databases[i].ws
+		j := i + 1
+		for j < len(databases) && databases[j].ws == ws {
+			j++
+		}
+		if ws != root {
+			restoreWorkspace(ws)
+		}
+		createSymlinkFarm(ws, databases[i:j])
+		for _, db := range databases[i:j] {
+			mapped = appendDatabases(mapped, []database{
+				{name: db.name, commands: mapEntryPaths(db.commands, ws, root), ws: root},
+			})
+		}
+		if ws != root {
+			restoreWorkspace(root)
+		}
+		i = j
+	}
+	return mapped
+}